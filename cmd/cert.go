@@ -0,0 +1,115 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/polarismesh/polaris-sidecar/internal/debugger"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls"
+)
+
+var (
+	debugAddr  = ""
+	outputJSON = false
+
+	certCmd = &cobra.Command{
+		Use:   "cert",
+		Short: "print the mtls certificate status",
+		Long:  "print the mtls workload certificate served by the running sidecar, which requires the debug server",
+		RunE: func(c *cobra.Command, args []string) error {
+			return printCertStatus(c.OutOrStdout())
+		},
+	}
+)
+
+/**
+ * @brief 解析命令参数
+ */
+func init() {
+	certCmd.PersistentFlags().StringVarP(&debugAddr, "debug-addr", "a",
+		fmt.Sprintf("127.0.0.1:%d", debugger.DefaultListenPort), "debug server address of the running sidecar")
+	certCmd.PersistentFlags().BoolVarP(&outputJSON, "json", "j", false, "print the raw json status")
+}
+
+func printCertStatus(w io.Writer) error {
+	cli := &http.Client{Timeout: 5 * time.Second}
+	resp, err := cli.Get("http://" + debugAddr + mtls.StatusPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("query certificate status failed with status %d, is mtls enabled?", resp.StatusCode)
+	}
+	if outputJSON {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	status := &mtls.CertificateStatus{}
+	if err = json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	rows := [][2]string{
+		{"Subject", status.Subject},
+		{"SPIFFE ID", status.SpiffeID},
+		{"URI SANs", strings.Join(status.URIs, ", ")},
+		{"DNS SANs", strings.Join(status.DNSNames, ", ")},
+		{"IP SANs", strings.Join(status.IPAddresses, ", ")},
+		{"Serial", status.SerialNumber},
+		{"Key Algorithm", status.KeyAlgorithm},
+		{"Not Before", formatTime(status.NotBefore)},
+		{"Not After", formatTime(status.NotAfter)},
+		{"Expires In", status.ExpiresIn},
+		{"Root Fingerprint", status.RootFingerprint},
+		{"Last Rotation", formatTime(status.Rotation.LastRotation)},
+		{"Next Rotation", formatTime(status.Rotation.NextRotation)},
+		{"Expiring", fmt.Sprint(status.Rotation.Expiring)},
+		{"Failures", fmt.Sprint(status.Rotation.ConsecutiveFailures)},
+		{"Last CA Error", status.Rotation.LastError},
+		{"Last CA Error Time", formatTime(status.Rotation.LastErrorTime)},
+		{"Error", status.Error},
+	}
+	for _, row := range rows {
+		if row[1] == "" {
+			continue
+		}
+		fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+	}
+	for i, cert := range status.Chain {
+		fmt.Fprintf(tw, "Chain[%d]:\t%s (issuer: %s, serial: %s, not after: %s)\n", i, cert.Subject, cert.Issuer,
+			cert.SerialNumber, formatTime(cert.NotAfter))
+	}
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -38,6 +38,7 @@ var (
 func init() {
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(certCmd)
 }
 
 /**
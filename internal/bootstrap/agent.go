@@ -57,16 +57,16 @@ func initAgent(configFilePath string, bootConfig *config.BootConfig) (*Agent, er
 	if err != nil {
 		return nil, err
 	}
-	agent.debugServer, err = sidecarConfig.InitDebugServer(agent.dnsResolver)
+	agent.mtlsAgent, err = sidecarConfig.InitMeshMtls()
 	if err != nil {
 		return nil, err
 	}
-	agent.metricServer = sidecarConfig.InitMeshMetrics()
-	agent.rlsSvr = sidecarConfig.InitMeshRatelimit()
-	agent.mtlsAgent, err = sidecarConfig.InitMeshMtls()
+	agent.debugServer, err = sidecarConfig.InitDebugServer(agent.dnsResolver, agent.mtlsAgent)
 	if err != nil {
 		return nil, err
 	}
+	agent.metricServer = sidecarConfig.InitMeshMetrics()
+	agent.rlsSvr = sidecarConfig.InitMeshRatelimit()
 	return agent, nil
 }
 
@@ -20,8 +20,10 @@ package config
 import (
 	"fmt"
 	"net"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	sdkconf "github.com/polarismesh/polaris-go/pkg/config"
@@ -31,10 +33,18 @@ import (
 	"github.com/polarismesh/polaris-sidecar/internal/debugger"
 	"github.com/polarismesh/polaris-sidecar/internal/mesh/metrics"
 	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/fileca"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/stepca"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/vault"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/sds"
 	"github.com/polarismesh/polaris-sidecar/internal/mesh/rls"
 	"github.com/polarismesh/polaris-sidecar/internal/resolver"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/acl"
 	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/limiter"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/qtype"
 	"github.com/polarismesh/polaris-sidecar/internal/resolver/recursor"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/rewrite"
 	"github.com/polarismesh/polaris-sidecar/pkg/constants"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 	"github.com/polarismesh/polaris-sidecar/pkg/polaris"
@@ -50,6 +60,10 @@ type SidecarConfig struct {
 	Logger        *log.Options          `yaml:"logger"`
 	Recurse       *RecurseConfig        `yaml:"recurse"`
 	Resolvers     []*common.ConfigEntry `yaml:"resolvers"`
+	QueryLimit    *limiter.Config       `yaml:"query_limit"`
+	ACL           *acl.Config           `yaml:"acl"`
+	Rewrite       []*rewrite.Rule       `yaml:"rewrite"`
+	QtypePolicy   *qtype.Config         `yaml:"qtype_policy"`
 	MeshConfig    *MeshConfig           `yaml:"mesh"`
 	Debugger      *debugger.DebugConfig `yaml:"debugger"`
 	DnsEnabled    bool                  `yaml:"-"`
@@ -96,6 +110,65 @@ type MeshConfig struct {
 type MeshMTLSConfig struct {
 	Enable   bool   `yaml:"enable"`
 	CAServer string `yaml:"ca_server"`
+	// CAServers addresses of the polaris CA, the next one is tried when the current one is unavailable
+	CAServers []string `yaml:"ca_servers"`
+	// CATimeoutSec max seconds of a request to each polaris CA server
+	CATimeoutSec int `yaml:"ca_timeout_sec"`
+	// CARootFile root certificate verifying the polaris CA server
+	CARootFile string `yaml:"ca_root_file"`
+	// CAClientCertFile and CAClientKeyFile client certificate authenticating to the polaris CA by mutual TLS
+	CAClientCertFile string `yaml:"ca_client_cert_file"`
+	CAClientKeyFile  string `yaml:"ca_client_key_file"`
+	// TokenPath service account token authenticating to the polaris CA
+	TokenPath string `yaml:"token_path"`
+	// TokenAudience expected audience of the projected service account token
+	TokenAudience string `yaml:"token_audience"`
+	// CAProvider CA backend signing the certificates, one of polaris, file, stepca, vault
+	CAProvider string `yaml:"ca_provider"`
+	// FileCA local CA used by the file provider
+	FileCA *fileca.Config `yaml:"file_ca"`
+	// StepCA step-ca style CA used by the stepca provider
+	StepCA *stepca.Config `yaml:"step_ca"`
+	// Vault Vault PKI secrets engine used by the vault provider
+	Vault *vault.Config `yaml:"vault"`
+	// KeyAlgorithm algorithm of the workload private key, one of RSA, ECDSA_P256, ECDSA_P384
+	KeyAlgorithm string `yaml:"key_algorithm"`
+	// RSAKeyBits bit size of the RSA key
+	RSAKeyBits int `yaml:"rsa_key_bits"`
+	// RotateFraction renew the certificate when the fraction of its lifetime has elapsed
+	RotateFraction float64 `yaml:"rotate_fraction"`
+	// RotateJitter random fraction of the lifetime added to or subtracted from the renewal time
+	RotateJitter float64 `yaml:"rotate_jitter"`
+	// ExpiryAlertSec alert when the certificate expires within the seconds and the renewal is failing
+	ExpiryAlertSec int `yaml:"expiry_alert_sec"`
+	// TrustDomain SPIFFE trust domain of the workload
+	TrustDomain string `yaml:"trust_domain"`
+	// URITemplate template of the URI SAN, {trust_domain}, {namespace} and {service_account} are replaced
+	URITemplate string `yaml:"uri_template"`
+	// DNSSANs extra DNS SANs of the workload certificate
+	DNSSANs []string `yaml:"dns_sans"`
+	// IPSANs extra IP SANs of the workload certificate
+	IPSANs []string `yaml:"ip_sans"`
+	// Namespace namespace of the workload identity, detected from the service account token if empty
+	Namespace string `yaml:"namespace"`
+	// ServiceAccount service account of the workload identity, detected from the service account token if empty
+	ServiceAccount string `yaml:"service_account"`
+	// BundleCacheDir directory to persist the issued key, chain and root, empty means disabled
+	BundleCacheDir string `yaml:"bundle_cache_dir"`
+	// OutputDir directory to write the key, chain and root for the applications other than envoy
+	OutputDir string `yaml:"output_dir"`
+	// OutputFileMode octal permission of the files in OutputDir, e.g. 0640
+	OutputFileMode string `yaml:"output_file_mode"`
+	// CertAPIAddress unix socket serving the X.509 SVIDs to the applications other than envoy
+	CertAPIAddress string `yaml:"cert_api_address"`
+	// CertAPIMode octal permission of the CertAPIAddress socket, e.g. 0660
+	CertAPIMode string `yaml:"cert_api_mode"`
+	// Identities extra workload identities served as the SDS secrets of their names
+	Identities []*mtls.Identity `yaml:"identities"`
+	// TrustBundles root certificate files of the federated trust domains by SDS secret name
+	TrustBundles map[string]string `yaml:"trust_bundles"`
+	// ValidationContexts served as the SDS secrets of their names
+	ValidationContexts []*sds.ValidationContext `yaml:"validation_contexts"`
 }
 
 // String toString output
@@ -136,6 +209,7 @@ func (s *SidecarConfig) InitDnsResolver() (*resolver.Server, error) {
 	resolveConfig := &common.ResolverConfig{
 		BindIP:    s.Bind,
 		BindPort:  uint32(s.Port),
+		Namespace: s.Namespace,
 		Resolvers: s.Resolvers,
 	}
 	var err error
@@ -148,7 +222,7 @@ func (s *SidecarConfig) InitDnsResolver() (*resolver.Server, error) {
 			return nil, err
 		}
 	}
-	svr, err := resolver.NewServer(resolveConfig, recurseProxyConf)
+	svr, err := resolver.NewServer(resolveConfig, recurseProxyConf, s.QueryLimit, s.ACL, s.Rewrite, s.QtypePolicy)
 	if err != nil {
 		log.Errorf("[bootstrap] fail to init dns server, err: %v", err)
 		return nil, err
@@ -157,16 +231,29 @@ func (s *SidecarConfig) InitDnsResolver() (*resolver.Server, error) {
 	return svr, nil
 }
 
-// InitDebugServer initializes the debug server based on the configuration.
-func (s *SidecarConfig) InitDebugServer(dnsServer *resolver.Server) (*debugger.DebugServer, error) {
-	if !s.isDebugEnabled() || dnsServer == nil {
+// InitDebugServer initializes the debug server based on the configuration, it is built when any of the
+// components registering the debug handlers is enabled.
+func (s *SidecarConfig) InitDebugServer(dnsServer *resolver.Server, mtlsAgent *mtls.Agent) (*debugger.DebugServer, error) {
+	if !s.isDebugEnabled() {
 		log.Infof("[bootstrap] debug server is not enabled, skip build")
 		return nil, nil
 	}
+	if dnsServer == nil && mtlsAgent == nil {
+		log.Infof("[bootstrap] no component registers debug handlers, skip build debug server")
+		return nil, nil
+	}
 	debugSvr := debugger.NewDebugServer(s.Bind, s.Debugger.Port)
-	if err := debugSvr.RegisterDebugHandler(dnsServer.Debugger()); err != nil {
-		log.Errorf("[bootstrap] fail to register debug handler, err: %v", err)
-		return nil, err
+	if dnsServer != nil {
+		if err := debugSvr.RegisterDebugHandler(dnsServer.Debugger()); err != nil {
+			log.Errorf("[bootstrap] fail to register debug handler, err: %v", err)
+			return nil, err
+		}
+	}
+	if mtlsAgent != nil {
+		if err := debugSvr.RegisterDebugHandler(mtlsAgent.Debugger()); err != nil {
+			log.Errorf("[bootstrap] fail to register mtls debug handler, err: %v", err)
+			return nil, err
+		}
 	}
 	log.Infof("[bootstrap] build debug server successfully")
 	return debugSvr, nil
@@ -189,8 +276,50 @@ func (s *SidecarConfig) InitMeshMtls() (*mtls.Agent, error) {
 		log.Infof("[bootstrap] mesh mtls agent is not enabled, skip build")
 		return nil, nil
 	}
+	outputFileMode, err := parseFileMode(s.MeshConfig.MTLS.OutputFileMode)
+	if err != nil {
+		log.Errorf("[bootstrap] invalid mesh mtls output file mode %s, err: %v",
+			s.MeshConfig.MTLS.OutputFileMode, err)
+		return nil, err
+	}
+	certAPIMode, err := parseFileMode(s.MeshConfig.MTLS.CertAPIMode)
+	if err != nil {
+		log.Errorf("[bootstrap] invalid mesh mtls cert api mode %s, err: %v",
+			s.MeshConfig.MTLS.CertAPIMode, err)
+		return nil, err
+	}
 	agent, err := mtls.New(mtls.Option{
-		CAServer: s.MeshConfig.MTLS.CAServer,
+		CAServer:             s.MeshConfig.MTLS.CAServer,
+		CAServers:            s.MeshConfig.MTLS.CAServers,
+		CATimeout:            time.Duration(s.MeshConfig.MTLS.CATimeoutSec) * time.Second,
+		CARootFile:           s.MeshConfig.MTLS.CARootFile,
+		CAClientCertFile:     s.MeshConfig.MTLS.CAClientCertFile,
+		CAClientKeyFile:      s.MeshConfig.MTLS.CAClientKeyFile,
+		CAProvider:           s.MeshConfig.MTLS.CAProvider,
+		TokenPath:            s.MeshConfig.MTLS.TokenPath,
+		TokenAudience:        s.MeshConfig.MTLS.TokenAudience,
+		FileCA:               s.MeshConfig.MTLS.FileCA,
+		StepCA:               s.MeshConfig.MTLS.StepCA,
+		Vault:                s.MeshConfig.MTLS.Vault,
+		KeyAlgorithm:         s.MeshConfig.MTLS.KeyAlgorithm,
+		RSAKeyBits:           s.MeshConfig.MTLS.RSAKeyBits,
+		RotateFraction:       s.MeshConfig.MTLS.RotateFraction,
+		RotateJitter:         s.MeshConfig.MTLS.RotateJitter,
+		ExpiryAlertThreshold: time.Duration(s.MeshConfig.MTLS.ExpiryAlertSec) * time.Second,
+		BundleCacheDir:       s.MeshConfig.MTLS.BundleCacheDir,
+		TrustDomain:          s.MeshConfig.MTLS.TrustDomain,
+		URITemplate:          s.MeshConfig.MTLS.URITemplate,
+		DNSNames:             s.MeshConfig.MTLS.DNSSANs,
+		IPAddresses:          s.MeshConfig.MTLS.IPSANs,
+		Namespace:            s.MeshConfig.MTLS.Namespace,
+		ServiceAccount:       s.MeshConfig.MTLS.ServiceAccount,
+		OutputDir:            s.MeshConfig.MTLS.OutputDir,
+		OutputFileMode:       outputFileMode,
+		CertAPIAddress:       s.MeshConfig.MTLS.CertAPIAddress,
+		CertAPIMode:          certAPIMode,
+		Identities:           s.MeshConfig.MTLS.Identities,
+		TrustBundles:         s.MeshConfig.MTLS.TrustBundles,
+		ValidationContexts:   s.MeshConfig.MTLS.ValidationContexts,
 	})
 	if err != nil {
 		log.Errorf("[bootstrap] fail to init mesh mtls agent, err: %v", err)
@@ -200,6 +329,18 @@ func (s *SidecarConfig) InitMeshMtls() (*mtls.Agent, error) {
 	return agent, nil
 }
 
+// parseFileMode parse the octal permission, 0 if empty
+func parseFileMode(value string) (os.FileMode, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(mode), nil
+}
+
 // InitMeshRatelimit initializes the mesh ratelimit server based on the configuration.
 func (s *SidecarConfig) InitMeshRatelimit() *rls.RateLimitServer {
 	if !s.isMeshRatelimitEnabled() {
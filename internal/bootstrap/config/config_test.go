@@ -21,6 +21,9 @@ import (
 	"fmt"
 	"os"
 	"testing"
+
+	"github.com/polarismesh/polaris-sidecar/internal/debugger"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls"
 )
 
 const testCfg = `logger:
@@ -127,3 +130,21 @@ func TestReplaceEnv(t *testing.T) {
 	fmt.Println("nextValue is " + nextValue)
 
 }
+
+func TestInitDebugServer(t *testing.T) {
+	cfg := &SidecarConfig{Debugger: &debugger.DebugConfig{Enable: true, Port: 30000}}
+	debugSvr, err := cfg.InitDebugServer(nil, nil)
+	if nil != err || debugSvr != nil {
+		t.Fatal("debug server should not be built without any component")
+	}
+	mtlsAgent, err := mtls.New(mtls.Option{CAProvider: mtls.CAProviderFile, Network: "tcp", Address: "127.0.0.1:0",
+		Namespace: "default", ServiceAccount: "foo"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	// 关闭 DNS 时仍然为 mtls 构建调试服务
+	debugSvr, err = cfg.InitDebugServer(nil, mtlsAgent)
+	if nil != err || debugSvr == nil {
+		t.Fatal("debug server should be built for the mtls agent")
+	}
+}
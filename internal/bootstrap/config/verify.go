@@ -6,6 +6,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/limiter"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
@@ -69,6 +70,10 @@ func (s *SidecarConfig) verify() error {
 			errs.Errors = append(errs.Errors, fmt.Errorf("resolver %d config dnsttl should greater or equals to 0",
 				idx))
 		}
+		if !common.IsValidAnswerOrder(resolverConfig.AnswerOrder) {
+			errs.Errors = append(errs.Errors, fmt.Errorf("resolver %d config answer_order should be one of "+
+				"random, weighted, client_hash", idx))
+		}
 		if resolverConfig.Enable {
 			if resolverConfig.Name == common.PluginNameDnsAgent {
 				s.DnsEnabled = true
@@ -77,6 +82,19 @@ func (s *SidecarConfig) verify() error {
 			}
 		}
 	}
+	if s.QueryLimit != nil && s.QueryLimit.Enable {
+		if s.QueryLimit.QPS < 0 || s.QueryLimit.Burst < 0 {
+			errs.Errors = append(errs.Errors, fmt.Errorf("query_limit.qps and query_limit.burst should greater "+
+				"or equals to 0"))
+		}
+		if !limiter.IsValidAction(s.QueryLimit.Action) {
+			errs.Errors = append(errs.Errors, fmt.Errorf("query_limit.action should be one of refused, truncate"))
+		}
+		if s.QueryLimit.RRL != nil && s.QueryLimit.RRL.Enable && !s.QueryLimit.RRL.IsValidPrefixLength() {
+			errs.Errors = append(errs.Errors, fmt.Errorf("query_limit.rrl.ipv4_prefix_length should be in [0, 32] "+
+				"and query_limit.rrl.ipv6_prefix_length should be in [0, 128]"))
+		}
+	}
 	if !s.DnsEnabled && !s.MeshEnabled {
 		errs.Errors = append(errs.Errors, fmt.Errorf("you should at least enable one resolver"))
 	}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/polarismesh/polaris-sidecar/internal/bootstrap/config"
 	"github.com/polarismesh/polaris-sidecar/internal/bootstrap/system"
+	"github.com/polarismesh/polaris-sidecar/pkg/graceful"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
@@ -30,6 +31,8 @@ func Start(configFilePath string, bootConfig *config.BootConfig) {
 	errCh := agent.getErrorChannel()
 	wg := &sync.WaitGroup{}
 	agent.runServices(ctx, wg, errCh)
+	// 由升级信号启动时，接管全部监听器后通知旧进程退出
+	go graceful.Ready(graceful.DefaultTakeOverTimeout)
 	runMainLoop(cancel, errCh)
 	<-ctx.Done()
 	log.Info("[bootstrap] sidecar server start shutdown")
@@ -61,12 +64,35 @@ func runMainLoop(cancel context.CancelFunc, errCh chan error) {
 		_ = log.Sync()
 	}()
 	signal.Notify(ch, system.Signals...)
+	// 升级在后台进行，等待新进程就绪期间仍然处理其他信号和错误
+	var upgradeCh chan error
 	for {
 		select {
 		case s := <-ch:
+			if s == system.UpgradeSignal {
+				if upgradeCh != nil {
+					log.Infof("[bootstrap] catch signal(%+v), upgrade is in progress, ignore it", s)
+					continue
+				}
+				log.Infof("[bootstrap] catch signal(%+v), start new sidecar server to take over listeners", s)
+				upgradeCh = make(chan error, 1)
+				go func(resultCh chan error) {
+					resultCh <- graceful.Upgrade(graceful.DefaultReadyTimeout)
+				}(upgradeCh)
+				continue
+			}
 			log.Infof("[bootstrap] catch signal(%+v), stop sidecar server", s)
 			cancel()
 			return
+		case err := <-upgradeCh:
+			upgradeCh = nil
+			if err != nil {
+				log.Errorf("[bootstrap] fail to upgrade sidecar server, keep serving, err: %v", err)
+				continue
+			}
+			log.Infof("[bootstrap] new sidecar server is ready, drain and stop sidecar server")
+			cancel()
+			return
 		case err := <-errCh:
 			log.Errorf("[bootstrap] main loop return for catch err: %s", err.Error())
 			cancel()
@@ -25,4 +25,8 @@ import (
 var Signals = []os.Signal{
 	syscall.SIGINT, syscall.SIGTERM,
 	syscall.SIGSEGV, syscall.SIGUSR1,
+	UpgradeSignal,
 }
+
+// UpgradeSignal start a new process to take over the listeners, and stop the current one after draining
+var UpgradeSignal os.Signal = syscall.SIGUSR2
@@ -26,3 +26,6 @@ var Signals = []os.Signal{
 	syscall.SIGINT, syscall.SIGTERM,
 	syscall.SIGSEGV,
 }
+
+// UpgradeSignal the listener takeover is only supported on linux
+var UpgradeSignal os.Signal
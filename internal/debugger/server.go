@@ -4,13 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
 	"net/http"
 	"net/http/pprof"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"github.com/polarismesh/polaris-sidecar/pkg/constants"
+	"github.com/polarismesh/polaris-sidecar/pkg/graceful"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
@@ -44,7 +46,7 @@ func (s *DebugServer) Run(ctx context.Context, wg *sync.WaitGroup, errChan chan
 		wg.Done()
 		s.Destroy()
 	}()
-	ln, err := net.Listen(constants.TcpProtocol, fmt.Sprintf("%s:%d", s.bind, s.port))
+	ln, err := graceful.Listen(constants.TcpProtocol, fmt.Sprintf("%s:%d", s.bind, s.port))
 	if err != nil {
 		log.Errorf(": %v", err)
 		errChan <- err
@@ -67,6 +69,7 @@ func (s *DebugServer) Run(ctx context.Context, wg *sync.WaitGroup, errChan chan
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/metrics", promhttp.Handler())
 	go func() {
 		errChan <- s.svr.Serve(ln)
 	}()
@@ -1,18 +1,26 @@
 package mtls
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 
-	caclient2 "github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/caclient"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certapi"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
 	manager2 "github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/manager"
 	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/rotator"
 	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/sds"
+	"github.com/polarismesh/polaris-sidecar/pkg/graceful"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
@@ -26,22 +34,39 @@ type Agent struct {
 	certManager manager2.Manager
 	rotator     *rotator.Rotator
 	once        sync.Once
+	opt         Option
+	// runCtx the context of Run, which stops the rotation of the identities generated on demand
+	runCtx context.Context
+	// identityMutex guard identities
+	identityMutex sync.Mutex
+	// identities the names of the identities generated on demand
+	identities map[string]struct{}
+	// trustBundles the roots of the federated trust domains by name
+	trustBundles map[string][]byte
+	// bundleMutex guard bundle
+	bundleMutex sync.Mutex
+	// bundle the workload certificate being served
+	bundle *certificate.Bundle
+	// certAPI the local API serving the certificate to the applications other than envoy, nil if disabled
+	certAPI *certapi.Server
 }
 
-const defaultCAPath = "/etc/polaris-sidecar/certs/rootca.pem"
-
 func New(opt Option) (*Agent, error) {
 	err := opt.init()
 	if err != nil {
 		log.Errorf("[envoy-mtls] init option failed: %v", err)
 		return nil, err
 	}
-	a := &Agent{}
+	a := &Agent{opt: opt, identities: map[string]struct{}{}}
 	a.network = opt.Network
 	a.addr = opt.Address
 	a.grpcSvr = grpc.NewServer()
-	a.rotator = rotator.New(opt.RotatePeriod, opt.FailedRetryDelay)
+	a.rotator = a.newRotator(sds.DefaultSecretName)
 	a.sds = sds.New(opt.CryptombPollDelay)
+	if err := a.initTrust(); err != nil {
+		log.Errorf("[envoy-mtls] init trust bundles failed: %v", err)
+		return nil, err
+	}
 
 	if opt.Network == "unix" {
 		if err := os.MkdirAll(filepath.Dir(opt.Address), os.ModePerm); err != nil {
@@ -49,15 +74,22 @@ func New(opt Option) (*Agent, error) {
 			return nil, err
 		}
 	}
+	if len(opt.CertAPIAddress) > 0 {
+		if err := os.MkdirAll(filepath.Dir(opt.CertAPIAddress), os.ModePerm); err != nil {
+			log.Errorf("[envoy-mtls] create cert api socket dir failed: %v", err)
+			return nil, err
+		}
+		a.certAPI = certapi.New()
+	}
 
-	cli, err := caclient2.NewWithRootCA(opt.CAServer, caclient2.ServiceAccountToken(), defaultCAPath)
+	cli, err := newCSRClient(&a.opt)
 	if err != nil {
-		log.Errorf("[envoy-mtls] create ca polaris failed: %v", err)
+		log.Errorf("[envoy-mtls] create ca %s failed: %v", opt.CAProvider, err)
 		return nil, err
 	}
 	a.client = cli
 
-	a.certManager = manager2.NewManager(opt.Namespace, opt.ServiceAccount, opt.RSAKeyBits, opt.TTL, a.client)
+	a.certManager = a.newManager(a.opt.identity())
 	return a, nil
 }
 
@@ -73,8 +105,10 @@ func (a *Agent) Run(ctx context.Context, wg *sync.WaitGroup, errChan chan error)
 		wg.Done()
 	}()
 	// start sds grpc service
+	a.runCtx = ctx
+	a.sds.SetGenerator(a.generateIdentity)
 	a.sds.Serve(a.grpcSvr)
-	l, err := net.Listen(a.network, a.addr)
+	l, err := graceful.Listen(a.network, a.addr)
 	if err != nil {
 		log.Errorf("[envoy-mtls] create sds grpc service listener failed: %v", err)
 		errChan <- err
@@ -84,16 +118,35 @@ func (a *Agent) Run(ctx context.Context, wg *sync.WaitGroup, errChan chan error)
 	go func() {
 		errChan <- a.grpcSvr.Serve(l)
 	}()
+	if len(a.opt.CertAPIAddress) > 0 {
+		if err = a.serveCertAPI(errChan); err != nil {
+			log.Errorf("[envoy-mtls] create cert api listener failed: %v", err)
+			errChan <- err
+			return
+		}
+	}
+	// 先使用磁盘上仍然有效的证书，由 rotator 在后台续期
+	current := a.loadCachedBundle(ctx)
 	log.Info("[envoy-mtls] start rotator")
 	// start certificate generation rotator
-	if err = a.rotator.Run(ctx, func(ctx context.Context) error {
+	if err = a.rotator.Run(ctx, current, func(ctx context.Context) (*x509.Certificate, error) {
 		bundle, err := a.certManager.GetBundle(ctx)
 		if err != nil {
 			log.Errorf("[envoy-mtls] get certificate bundle failed: %v", err)
-			return err
+			return nil, err
 		}
-		a.sds.UpdateSecrets(ctx, *bundle)
-		return nil
+		a.publish(ctx, bundle)
+		if len(a.opt.BundleCacheDir) > 0 {
+			if err := certificate.SaveBundle(a.opt.BundleCacheDir, bundle, a.caSource()); err != nil {
+				log.Errorf("[envoy-mtls] save certificate bundle to %s failed: %v", a.opt.BundleCacheDir, err)
+			}
+		}
+		leaf, err := certificate.ParseLeaf(bundle.CertChain)
+		if err != nil {
+			log.Warnf("[envoy-mtls] parse certificate failed, rotate by period: %v", err)
+			return nil, nil
+		}
+		return leaf, nil
 	}); err != nil {
 		log.Errorf("[envoy-mtls] start rotator failed: %v", err)
 		errChan <- err
@@ -103,9 +156,121 @@ func (a *Agent) Run(ctx context.Context, wg *sync.WaitGroup, errChan chan error)
 	log.Infof("[envoy-mtls] receive stop signal, return")
 }
 
+// loadCachedBundle serve the bundle persisted on disk if it is still valid for the workload,
+// and returns its leaf certificate
+func (a *Agent) loadCachedBundle(ctx context.Context) *x509.Certificate {
+	if len(a.opt.BundleCacheDir) == 0 {
+		return nil
+	}
+	bundle, source, err := certificate.LoadBundle(a.opt.BundleCacheDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("[envoy-mtls] load certificate bundle from %s failed: %v", a.opt.BundleCacheDir, err)
+		}
+		return nil
+	}
+	leaf, err := certificate.ParseLeaf(bundle.CertChain)
+	if err != nil {
+		log.Warnf("[envoy-mtls] parse cached certificate failed: %v", err)
+		return nil
+	}
+	if source != a.caSource() {
+		log.Infof("[envoy-mtls] skip cached certificate %s: ca changed to %s", leaf.SerialNumber, a.caSource())
+		return nil
+	}
+	if reason := a.invalidReason(bundle, leaf, time.Now()); len(reason) > 0 {
+		log.Infof("[envoy-mtls] skip cached certificate %s: %s", leaf.SerialNumber, reason)
+		return nil
+	}
+	a.publish(ctx, bundle)
+	log.Infof("[envoy-mtls] serve cached certificate %s, expires at %v", leaf.SerialNumber, leaf.NotAfter)
+	return leaf
+}
+
+// invalidReason returns why the cached bundle can not be used, empty if it is valid
+func (a *Agent) invalidReason(bundle *certificate.Bundle, leaf *x509.Certificate, now time.Time) string {
+	if now.Before(leaf.NotBefore) || !now.Before(leaf.NotAfter) {
+		return "expired"
+	}
+	if err := certificate.VerifyChain(bundle, now); err != nil {
+		return "not issued by the cached root: " + err.Error()
+	}
+	// CA 已知根证书时，根证书变化后缓存的证书不再可用
+	if rooted, ok := a.client.(rootPEMProvider); ok && !bytes.Equal(bytes.TrimSpace(rooted.RootPEM()),
+		bytes.TrimSpace(bundle.ROOTCA)) {
+		return "root changed"
+	}
+	if bundle.KeyAlgorithm != a.opt.KeyAlgorithm {
+		return "key algorithm changed to " + a.opt.KeyAlgorithm
+	}
+	identity := a.opt.identity()
+	uri, err := identity.URI()
+	if err != nil {
+		return err.Error()
+	}
+	if len(leaf.URIs) != 1 || leaf.URIs[0].String() != uri.String() {
+		return "identity changed to " + uri.String()
+	}
+	if !sameStrings(leaf.DNSNames, identity.DNSNames) {
+		return fmt.Sprintf("dns sans changed to %v", identity.DNSNames)
+	}
+	ips := make([]string, 0, len(leaf.IPAddresses))
+	for _, ip := range leaf.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	expectIPs := make([]string, 0, len(identity.IPAddresses))
+	for _, ip := range identity.IPAddresses {
+		expectIPs = append(expectIPs, ip.String())
+	}
+	if !sameStrings(ips, expectIPs) {
+		return fmt.Sprintf("ip sans changed to %v", expectIPs)
+	}
+	return ""
+}
+
+// rootPEMProvider the CA knowing its root before signing
+type rootPEMProvider interface {
+	RootPEM() []byte
+}
+
+// caSource identifies the CA issuing the certificates, the cached bundle of another CA is not served
+func (a *Agent) caSource() string {
+	switch a.opt.CAProvider {
+	case CAProviderPolaris:
+		return CAProviderPolaris + ":" + strings.Join(a.opt.CAServers, ",")
+	case CAProviderStepCA:
+		return CAProviderStepCA + ":" + a.opt.StepCA.Endpoint
+	case CAProviderVault:
+		return CAProviderVault + ":" + a.opt.Vault.Address + "/" + a.opt.Vault.Mount
+	}
+	return a.opt.CAProvider
+}
+
+// sameStrings returns whether the two lists have the same values regardless of the order
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Destroy stop the agent
 func (a *Agent) Destroy() {
 	a.once.Do(func() {
+		if a.certAPI != nil {
+			if err := a.certAPI.Close(); err != nil {
+				log.Errorf("[envoy-mtls] close cert api failed: %v", err)
+			}
+		}
 		if a.grpcSvr != nil {
 			a.grpcSvr.GracefulStop()
 		}
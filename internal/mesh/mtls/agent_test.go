@@ -0,0 +1,100 @@
+package mtls
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certapi"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/fileca"
+)
+
+func TestAgent_invalidReason(t *testing.T) {
+	ca, err := fileca.NewSelfSigned(certificate.DefaultTrustDomain)
+	assert.NoError(t, err)
+	other, err := fileca.NewSelfSigned(certificate.DefaultTrustDomain)
+	assert.NoError(t, err)
+	a := &Agent{client: ca, opt: Option{
+		KeyAlgorithm:   certificate.KeyAlgorithmECDSAP256,
+		TrustDomain:    certificate.DefaultTrustDomain,
+		URITemplate:    certificate.DefaultURITemplate,
+		Namespace:      "default",
+		ServiceAccount: "foo",
+	}}
+	priv, err := certificate.GenerateKey(certificate.KeyAlgorithmECDSAP256, 0)
+	assert.NoError(t, err)
+	csr, err := certificate.GenerateCSR(a.opt.identity(), priv)
+	assert.NoError(t, err)
+	chain, root, err := ca.CreateCertificate(context.Background(), csr, time.Hour)
+	assert.NoError(t, err)
+	bundle := &certificate.Bundle{CertChain: []byte(chain), ROOTCA: []byte(root),
+		KeyAlgorithm: certificate.KeyAlgorithmECDSAP256}
+	leaf, err := certificate.ParseLeaf(bundle.CertChain)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	assert.Empty(t, a.invalidReason(bundle, leaf, now))
+	assert.Equal(t, "expired", a.invalidReason(bundle, leaf, now.Add(2*time.Hour)))
+	// 缓存的根证书与证书链不匹配
+	assert.Contains(t, a.invalidReason(&certificate.Bundle{CertChain: bundle.CertChain, ROOTCA: other.RootPEM(),
+		KeyAlgorithm: bundle.KeyAlgorithm}, leaf, now), "not issued by the cached root")
+	// CA 的根证书已经变化
+	a.client = other
+	assert.Equal(t, "root changed", a.invalidReason(bundle, leaf, now))
+}
+
+func TestAgent_resolveIdentity(t *testing.T) {
+	a := &Agent{opt: Option{
+		TrustDomain:    certificate.DefaultTrustDomain,
+		URITemplate:    certificate.DefaultURITemplate,
+		Namespace:      "default",
+		ServiceAccount: "foo",
+		Identities:     []*Identity{{Name: "payment", Namespace: "billing", ServiceAccount: "payment"}},
+	}}
+	for _, name := range []string{"payment", "spiffe://cluster.local/ns/billing/sa/payment",
+		"spiffe://cluster.local/ns/default/sa/foo"} {
+		_, ok := a.resolveIdentity(name)
+		assert.True(t, ok, name)
+	}
+	identity, _ := a.resolveIdentity("payment")
+	assert.Equal(t, "billing", identity.Namespace)
+	// 未配置的身份不签发
+	for _, name := range []string{"spiffe://cluster.local/ns/kube-system/sa/admin", "spiffe://example.com/ns/default/sa/foo",
+		"unknown"} {
+		_, ok := a.resolveIdentity(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestAgent_serveCertAPI(t *testing.T) {
+	a := &Agent{certAPI: certapi.New(), opt: Option{
+		CertAPIAddress: filepath.Join(t.TempDir(), "cert.sock"),
+		CertAPIMode:    0660,
+	}}
+	assert.NoError(t, a.serveCertAPI(make(chan error, 1)))
+	defer a.certAPI.Close()
+	info, err := os.Stat(a.opt.CertAPIAddress)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+}
+
+func TestOption_initKeyAlgorithm(t *testing.T) {
+	newOption := func(algorithm string) *Option {
+		return &Option{
+			CAProvider:     CAProviderFile,
+			Namespace:      "default",
+			ServiceAccount: "foo",
+			KeyAlgorithm:   algorithm,
+		}
+	}
+	opt := newOption("ecdsa_p256")
+	assert.NoError(t, opt.init())
+	assert.Equal(t, certificate.KeyAlgorithmECDSAP256, opt.KeyAlgorithm)
+	// envoy 不支持通过 SDS 下发 ED25519 证书
+	assert.ErrorContains(t, newOption("ed25519").init(), "not supported by envoy")
+}
@@ -0,0 +1,47 @@
+package mtls
+
+import (
+	"fmt"
+
+	caclient2 "github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/caclient"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/fileca"
+	manager2 "github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/manager"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/stepca"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/vault"
+)
+
+const (
+	// CAProviderPolaris the polaris security service
+	CAProviderPolaris = "polaris"
+	// CAProviderFile the local CA of the files or self signed, for development and air-gapped testing
+	CAProviderFile = "file"
+	// CAProviderStepCA the step-ca style HTTP CA
+	CAProviderStepCA = "stepca"
+	// CAProviderVault the Vault PKI secrets engine
+	CAProviderVault = "vault"
+)
+
+const DefaultCARootFile = "/etc/polaris-sidecar/certs/rootca.pem"
+
+// newCSRClient returns the client of the CA provider
+func newCSRClient(opt *Option) (manager2.CSRClient, error) {
+	tokens := caclient2.NewFileToken(opt.TokenPath, opt.TokenAudience)
+	switch opt.CAProvider {
+	case CAProviderPolaris:
+		return caclient2.NewWithOptions(&caclient2.Options{
+			Endpoints:  opt.CAServers,
+			RootCAFile: opt.CARootFile,
+			CertFile:   opt.CAClientCertFile,
+			KeyFile:    opt.CAClientKeyFile,
+			Timeout:    opt.CATimeout,
+			Tokens:     tokens,
+		})
+	case CAProviderFile:
+		return fileca.New(opt.FileCA, opt.TrustDomain)
+	case CAProviderStepCA:
+		return stepca.New(opt.StepCA, tokens)
+	case CAProviderVault:
+		return vault.New(opt.Vault, tokens)
+	}
+	return nil, fmt.Errorf("unsupported ca provider %s", opt.CAProvider)
+}
@@ -0,0 +1,124 @@
+package certapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	// X509SVIDPath returns the current X.509 SVIDs, with watch=true the updates are streamed
+	// as newline delimited JSON until the client disconnects
+	X509SVIDPath = "/v1/x509svid"
+)
+
+// X509SVID the X.509 SVID of the workload in PEM, the field names are borrowed from the SPIFFE Workload API
+// but the encoding is not the same
+type X509SVID struct {
+	SpiffeID string `json:"spiffe_id"`
+	// X509SVID the PEM encoded certificate chain, the leaf comes first
+	X509SVID string `json:"x509_svid"`
+	// X509SVIDKey the PEM encoded PKCS#8 private key
+	X509SVIDKey string `json:"x509_svid_key"`
+	// Bundle the PEM encoded roots of the trust domain
+	Bundle string `json:"bundle"`
+}
+
+// X509SVIDResponse the SVIDs and the bundles of the federated trust domains by name
+type X509SVIDResponse struct {
+	SVIDs            []*X509SVID       `json:"svids"`
+	FederatedBundles map[string]string `json:"federated_bundles,omitempty"`
+}
+
+// Server the local HTTP/JSON API exposing the workload certificates to the applications other than envoy,
+// e.g. proxyless gRPC and Java services. It is not the gRPC SPIFFE Workload API, so the SPIFFE client libraries
+// such as go-spiffe can not use it, the clients should read the JSON or use the output dir instead.
+type Server struct {
+	mutex   sync.Mutex
+	current *X509SVIDResponse
+	// updated is closed and replaced on each update to wake up the watchers
+	updated chan struct{}
+	srv     *http.Server
+}
+
+func New() *Server {
+	s := &Server{updated: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc(X509SVIDPath, s.handleX509SVID)
+	s.srv = &http.Server{Handler: mux}
+	return s
+}
+
+// Update replace the SVIDs and notify the watchers
+func (s *Server) Update(resp *X509SVIDResponse) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.current = resp
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// snapshot returns the current SVIDs and the channel closed on the next update
+func (s *Server) snapshot() (*X509SVIDResponse, chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.current, s.updated
+}
+
+// Serve serve the API on the listener until Close
+func (s *Server) Serve(ln net.Listener) error {
+	if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stop the server and disconnect the watchers
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+func (s *Server) handleX509SVID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	current, updated := s.snapshot()
+	if r.URL.Query().Get("watch") != "true" {
+		if current == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(current)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	// 立即返回响应头，证书未签发时订阅者也能建立连接
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		if current != nil {
+			if err := enc.Encode(current); err != nil {
+				log.Warnf("[envoy-mtls] write x509 svid to watcher failed: %v", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-updated:
+		}
+		current, updated = s.snapshot()
+	}
+}
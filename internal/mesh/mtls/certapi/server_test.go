@@ -0,0 +1,62 @@
+package certapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_X509SVID(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "cert.sock")
+	ln, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	s := New()
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	defer s.Close()
+
+	cli := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := cli.Get("http://certapi" + X509SVIDPath)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// 订阅者在第一次更新后收到证书，之后每次轮换都会收到推送
+	watch, err := cli.Get("http://certapi" + X509SVIDPath + "?watch=true")
+	assert.NoError(t, err)
+	defer watch.Body.Close()
+	s.Update(&X509SVIDResponse{SVIDs: []*X509SVID{{SpiffeID: "spiffe://cluster.local/ns/default/sa/foo",
+		X509SVID: "chain-1"}}})
+	reader := bufio.NewReader(watch.Body)
+	update := &X509SVIDResponse{}
+	line, err := reader.ReadBytes('\n')
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(line, update))
+	assert.Equal(t, "chain-1", update.SVIDs[0].X509SVID)
+
+	s.Update(&X509SVIDResponse{SVIDs: []*X509SVID{{SpiffeID: "spiffe://cluster.local/ns/default/sa/foo",
+		X509SVID: "chain-2"}}, FederatedBundles: map[string]string{"partner": "root"}})
+	line, err = reader.ReadBytes('\n')
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(line, update))
+	assert.Equal(t, "chain-2", update.SVIDs[0].X509SVID)
+	assert.Equal(t, "root", update.FederatedBundles["partner"])
+
+	resp, err = cli.Get("http://certapi" + X509SVIDPath)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	current := &X509SVIDResponse{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(current))
+	assert.Equal(t, "chain-2", current.SVIDs[0].X509SVID)
+}
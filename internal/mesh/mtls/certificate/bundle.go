@@ -1,36 +1,38 @@
 package certificate
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
-	"fmt"
-	"net/url"
+	"errors"
+	"time"
 )
 
 type Bundle struct {
 	ROOTCA    []byte
 	CertChain []byte
 	PrivKey   []byte
+	// KeyAlgorithm the algorithm of PrivKey
+	KeyAlgorithm string
 }
 
-const (
-	SchemeSPIFFE = "spiffe"
-	TrustDomain  = "cluster.local"
-)
-
-func GenerateCSR(ns string, sa string, priv interface{}) (csr []byte, err error) {
+// GenerateCSR generate the CSR of the identity signed by the private key, the signature algorithm is chosen
+// by the key type
+func GenerateCSR(identity *Identity, priv crypto.Signer) (csr []byte, err error) {
+	uri, err := identity.URI()
+	if err != nil {
+		return nil, err
+	}
 	// certificate must meet the SPIFFE document: https://github.com/spiffe/spiffe/blob/main/standards/X509-SVID.md
 	tpl := &x509.CertificateRequest{}
 	tpl.Subject = pkix.Name{
-		Organization: []string{TrustDomain},
+		Organization: []string{identity.trustDomain()},
 	}
-	tpl.URIs = append(tpl.URIs, &url.URL{
-		Scheme: SchemeSPIFFE,
-		Host:   TrustDomain,
-		Path:   fmt.Sprintf("/ns/%s/sa/%s", ns, sa),
-	})
+	tpl.URIs = append(tpl.URIs, uri)
+	tpl.DNSNames = identity.DNSNames
+	tpl.IPAddresses = identity.IPAddresses
 
 	csr, err = x509.CreateCertificateRequest(rand.Reader, tpl, priv)
 	if err != nil {
@@ -40,3 +42,54 @@ func GenerateCSR(ns string, sa string, priv interface{}) (csr []byte, err error)
 	csr = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
 	return csr, nil
 }
+
+// ParseLeaf returns the first certificate in the PEM encoded chain
+func ParseLeaf(chain []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			return nil, errors.New("no certificate found in chain")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// VerifyChain check that the leaf certificate chains to the root of the bundle at the time
+func VerifyChain(bundle *Bundle, now time.Time) error {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle.ROOTCA) {
+		return errors.New("no root certificate found")
+	}
+	var certs []*x509.Certificate
+	for rest := bundle.CertChain; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return errors.New("no certificate found in chain")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
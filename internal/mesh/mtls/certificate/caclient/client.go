@@ -7,68 +7,158 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
+const (
+	// DefaultTimeout the max duration of a request to the CA
+	DefaultTimeout = 10 * time.Second
+
+	signCertificatePath = "/security/v1/sign_certificate"
+)
+
 type Client struct {
-	client   *http.Client
-	token    string
-	endpoint string
+	client    *http.Client
+	tokens    TokenSource
+	endpoints []string
+	timeout   time.Duration
+
+	mutex sync.Mutex
+	// current the index of the endpoint tried first, it moves to the next one on failure
+	current int
+}
+
+// Options the options of the client to the polaris CA
+type Options struct {
+	// Endpoints the addresses of the CA, the next one is tried when the current one is unavailable
+	Endpoints []string
+	// RootCAFile the roots to verify the CA server besides the system roots
+	RootCAFile string
+	// CertFile and KeyFile the client certificate to authenticate to the CA by mutual TLS, empty means disabled.
+	// They are reloaded on each handshake, so that they can be rotated.
+	CertFile string
+	KeyFile  string
+	// Timeout the max duration of a request to each endpoint, default is 10s
+	Timeout time.Duration
+	// Tokens the token source, default is the service account token
+	Tokens TokenSource
 }
 
 func NewCAClient(endpoint string) (*Client, error) {
 	return New(endpoint, "", nil)
 }
 
-func NewWithRootCA(endpoint string, token string, rootcaFile string) (*Client, error) {
-	certPEMBlock, err := os.ReadFile(rootcaFile)
+func NewWithRootCA(endpoint string, tokens TokenSource, rootcaFile string) (*Client, error) {
+	return NewWithOptions(&Options{Endpoints: []string{endpoint}, RootCAFile: rootcaFile, Tokens: tokens})
+}
+
+// NewWithOptions returns the client with the endpoints, the TLS settings and the timeout
+func NewWithOptions(opts *Options) (*Client, error) {
+	cli, err := NewTLSHTTPClient(opts.RootCAFile, opts.CertFile, opts.KeyFile)
 	if err != nil {
 		return nil, err
 	}
-
-	pool, err := x509.SystemCertPool()
+	tokens := opts.Tokens
+	if tokens == nil {
+		tokens = NewFileToken(SATLocation, "")
+	}
+	c, err := newClient(opts.Endpoints, tokens, cli)
 	if err != nil {
 		return nil, err
 	}
-	pool.AppendCertsFromPEM(certPEMBlock)
-	cli := &http.Client{Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{
-			RootCAs: pool,
-		},
-	}}
+	if opts.Timeout > 0 {
+		c.timeout = opts.Timeout
+		cli.Timeout = opts.Timeout
+	}
+	return c, nil
+}
 
-	return New(endpoint, token, cli)
+// NewHTTPClient returns the http client trusting the system roots and the roots in the file
+func NewHTTPClient(rootcaFile string) (*http.Client, error) {
+	return NewTLSHTTPClient(rootcaFile, "", "")
 }
 
-func New(endpoint string, token string, client *http.Client) (*Client, error) {
-	u, err := url.Parse(endpoint) // must be a valid endpoint
+// NewTLSHTTPClient returns the http client trusting the system roots and the roots in the file, and presenting
+// the client certificate if certFile and keyFile are provided
+func NewTLSHTTPClient(rootcaFile string, certFile string, keyFile string) (*http.Client, error) {
+	pool, err := x509.SystemCertPool()
 	if err != nil {
 		return nil, err
 	}
-
-	if sc := strings.ToUpper(u.Scheme); sc != "HTTP" && sc != "HTTPS" {
-		return nil, errors.New("unsupported endpoint scheme")
+	if rootcaFile != "" {
+		certPEMBlock, err := os.ReadFile(rootcaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool.AppendCertsFromPEM(certPEMBlock)
+	}
+	tlsConfig := &tls.Config{
+		RootCAs: pool,
+	}
+	if certFile != "" || keyFile != "" {
+		// 提前加载一次，配置错误时启动失败
+		if _, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("load ca client certificate: %w", err)
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
 	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: DefaultTimeout}, nil
+}
 
+// New returns the client authenticated by the token, the service account token is used if it is empty
+func New(endpoint string, token string, client *http.Client) (*Client, error) {
+	var tokens TokenSource = StaticToken(token)
 	if token == "" {
-		sat := ServiceAccountToken()
-		if sat != "" {
-			token = sat
+		tokens = NewFileToken(SATLocation, "")
+	}
+	return NewWithTokenSource(endpoint, tokens, client)
+}
+
+// NewWithTokenSource returns the client authenticated by the token of the source, which is reloaded
+// when the CA rejects it
+func NewWithTokenSource(endpoint string, tokens TokenSource, client *http.Client) (*Client, error) {
+	return newClient([]string{endpoint}, tokens, client)
+}
+
+func newClient(endpoints []string, tokens TokenSource, client *http.Client) (*Client, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no ca endpoint provided")
+	}
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint) // must be a valid endpoint
+		if err != nil {
+			return nil, err
+		}
+
+		if sc := strings.ToUpper(u.Scheme); sc != "HTTP" && sc != "HTTPS" {
+			return nil, errors.New("unsupported endpoint scheme")
 		}
 	}
+
 	if client == nil {
-		client = http.DefaultClient
+		client = &http.Client{Timeout: DefaultTimeout}
 	}
 	return &Client{
-		token:    token,
-		endpoint: endpoint,
-		client:   client,
+		tokens:    tokens,
+		endpoints: endpoints,
+		client:    client,
+		timeout:   DefaultTimeout,
 	}, nil
 }
 
@@ -87,8 +177,73 @@ func (c *CreateCertificateRequest) Payload() *bytes.Buffer {
 }
 
 func (c *Client) CreateCertificate(ctx context.Context, csr []byte, ttl time.Duration) (certChanPem string, rootca string, err error) {
-	req, err := http.NewRequest("POST",
-		c.endpoint+"/security/v1/sign_certificate",
+	token, err := c.tokens.Token()
+	if err != nil {
+		return "", "", err
+	}
+	certChanPem, rootca, err = c.createCertificate(ctx, csr, ttl, token)
+	if errors.Is(err, errUnauthorized) {
+		// 令牌可能已经轮换，强制重新加载后重试一次
+		log.Warnf("[envoy-mtls] token is rejected by the ca, reload and retry")
+		c.tokens.Invalidate()
+		if token, err = c.tokens.Token(); err != nil {
+			return "", "", err
+		}
+		certChanPem, rootca, err = c.createCertificate(ctx, csr, ttl, token)
+	}
+	return certChanPem, rootca, err
+}
+
+var errUnauthorized = errors.New("unauthorized by the ca")
+
+// unavailableError the endpoint can not serve the request, so the next one is tried
+type unavailableError struct {
+	endpoint string
+	err      error
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("ca %s is unavailable: %v", e.endpoint, e.err)
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+// createCertificate try the endpoints in turn from the current one, until one of them responds
+func (c *Client) createCertificate(ctx context.Context, csr []byte, ttl time.Duration, token string) (string, string, error) {
+	c.mutex.Lock()
+	start := c.current
+	c.mutex.Unlock()
+	var lastErr error
+	for i := 0; i < len(c.endpoints); i++ {
+		index := (start + i) % len(c.endpoints)
+		chain, root, err := c.createCertificateFrom(ctx, c.endpoints[index], csr, ttl, token)
+		var ue *unavailableError
+		if !errors.As(err, &ue) {
+			c.mutex.Lock()
+			c.current = index
+			c.mutex.Unlock()
+			return chain, root, err
+		}
+		lastErr = err
+		if ctx.Err() != nil || i == len(c.endpoints)-1 {
+			break
+		}
+		log.Warnf("[envoy-mtls] %v, try the next ca", err)
+	}
+	c.mutex.Lock()
+	c.current = (start + 1) % len(c.endpoints)
+	c.mutex.Unlock()
+	return "", "", lastErr
+}
+
+func (c *Client) createCertificateFrom(ctx context.Context, endpoint string, csr []byte, ttl time.Duration,
+	token string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		endpoint+signCertificatePath,
 		(&CreateCertificateRequest{
 			CSR: string(csr),
 			TTL: ttl.Milliseconds() / 1000,
@@ -98,22 +253,29 @@ func (c *Client) CreateCertificate(ctx context.Context, csr []byte, ttl time.Dur
 		return "", "", err
 	}
 	// oauth2 token style
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+token)
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", "", err
+		return "", "", &unavailableError{endpoint: endpoint, err: err}
 	}
 	dec := json.NewDecoder(resp.Body)
 	defer func() {
-		if err = resp.Body.Close(); err != nil {
+		if err := resp.Body.Close(); err != nil {
 			log.Errorf("failed to close response body: %v", err)
 		}
 	}()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", "", errUnauthorized
+	}
 	ccr := &CreateCertificateResponse{}
 
 	err = dec.Decode(ccr)
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return "", "", &unavailableError{endpoint: endpoint,
+			err: fmt.Errorf("status %d: %s", resp.StatusCode, ccr.Message)}
+	}
 	if err != nil {
-		return "", "", err
+		return "", "", &unavailableError{endpoint: endpoint, err: err}
 	}
 	if resp.StatusCode != http.StatusOK {
 		return "", "", errors.New(ccr.Message)
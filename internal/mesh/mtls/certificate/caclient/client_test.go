@@ -2,13 +2,25 @@ package caclient
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+
 	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/fileca"
 )
 
 func TestCreateCertificate(t *testing.T) {
@@ -20,13 +32,13 @@ func TestCreateCertificate(t *testing.T) {
 		t.Skip()
 		return
 	}
-	cli, err := NewWithRootCA(certEndpoint, sat, rootca)
+	cli, err := NewWithRootCA(certEndpoint, StaticToken(sat), rootca)
 	if err != nil {
 		t.Fatal(err)
 	}
 	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
 
-	csr, _ := certificate.GenerateCSR("default", "default", priv)
+	csr, _ := certificate.GenerateCSR(certificate.NewIdentity("default", "default"), priv)
 	chain, root, err := cli.CreateCertificate(context.TODO(), csr, time.Second)
 	if err != nil {
 		t.Fatal(err)
@@ -34,3 +46,171 @@ func TestCreateCertificate(t *testing.T) {
 	t.Log("cert chain:", chain)
 	t.Log("root ca", root)
 }
+
+func TestCreateCertificate_ReloadToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer rotated" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("token expired"))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&CreateCertificateResponse{CertChain: "chain", RootCert: "root"})
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(path, []byte("expired"), 0600))
+	tokens := NewFileToken(path, "")
+	cli, err := NewWithTokenSource(srv.URL, tokens, srv.Client())
+	assert.NoError(t, err)
+	_, _, err = cli.CreateCertificate(context.Background(), []byte("csr"), time.Hour)
+	assert.ErrorIs(t, err, errUnauthorized)
+
+	// 文件修改时间未变化，依靠 401 后的强制重新加载读取新 token
+	info, _ := os.Stat(path)
+	assert.NoError(t, os.WriteFile(path, []byte("rotated"), 0600))
+	assert.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	chain, root, err := cli.CreateCertificate(context.Background(), []byte("csr"), time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "chain", chain)
+	assert.Equal(t, "root", root)
+}
+
+func signHandler(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(&CreateCertificateResponse{CertChain: "chain", RootCert: "root"})
+}
+
+func TestCreateCertificate_Failover(t *testing.T) {
+	var unavailable int
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unavailable++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	closed := httptest.NewServer(http.HandlerFunc(signHandler))
+	closed.Close()
+	up := httptest.NewServer(http.HandlerFunc(signHandler))
+	defer up.Close()
+
+	cli, err := NewWithOptions(&Options{
+		Endpoints: []string{down.URL, closed.URL, up.URL},
+		Tokens:    StaticToken("token"),
+	})
+	assert.NoError(t, err)
+	chain, root, err := cli.CreateCertificate(context.Background(), []byte("csr"), time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "chain", chain)
+	assert.Equal(t, "root", root)
+	assert.Equal(t, 1, unavailable)
+
+	// 后续请求直接使用可用的 CA
+	_, _, err = cli.CreateCertificate(context.Background(), []byte("csr"), time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, unavailable)
+}
+
+func TestCreateCertificate_Rejected(t *testing.T) {
+	var rejected int
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rejected++
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&CreateCertificateResponse{Message: "invalid csr"})
+	}))
+	defer reject.Close()
+	up := httptest.NewServer(http.HandlerFunc(signHandler))
+	defer up.Close()
+
+	// CA 拒绝签发时不切换地址
+	cli, err := NewWithOptions(&Options{Endpoints: []string{reject.URL, up.URL}, Tokens: StaticToken("token")})
+	assert.NoError(t, err)
+	_, _, err = cli.CreateCertificate(context.Background(), []byte("csr"), time.Hour)
+	assert.EqualError(t, err, "invalid csr")
+	assert.Equal(t, 1, rejected)
+}
+
+func TestCreateCertificate_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	cli, err := NewWithOptions(&Options{
+		Endpoints: []string{hung.URL},
+		Tokens:    StaticToken("token"),
+		Timeout:   100 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	start := time.Now()
+	_, _, err = cli.CreateCertificate(context.Background(), []byte("csr"), time.Hour)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// 调用方取消时立即返回
+	cli, err = NewWithOptions(&Options{Endpoints: []string{hung.URL}, Tokens: StaticToken("token"), Timeout: time.Minute})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	_, _, err = cli.CreateCertificate(ctx, []byte("csr"), time.Hour)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestCreateCertificate_MutualTLS(t *testing.T) {
+	ca, err := fileca.NewSelfSigned(certificate.DefaultTrustDomain)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.RootPEM())
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Len(t, r.TLS.PeerCertificates, 1)
+		signHandler(w, r)
+	}))
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	rootFile := filepath.Join(dir, "rootca.pem")
+	assert.NoError(t, os.WriteFile(rootFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+
+	// 未配置客户端证书时握手失败
+	cli, err := NewWithOptions(&Options{Endpoints: []string{srv.URL}, RootCAFile: rootFile, Tokens: StaticToken("token")})
+	assert.NoError(t, err)
+	_, _, err = cli.CreateCertificate(context.Background(), []byte("csr"), time.Hour)
+	assert.Error(t, err)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	csr, err := certificate.GenerateCSR(certificate.NewIdentity("default", "default"), priv)
+	assert.NoError(t, err)
+	_, certPEM, err := ca.Sign(csr, time.Hour)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	assert.NoError(t, err)
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	assert.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+	cli, err = NewWithOptions(&Options{
+		Endpoints:  []string{srv.URL},
+		RootCAFile: rootFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		Tokens:     StaticToken("token"),
+	})
+	assert.NoError(t, err)
+	chain, _, err := cli.CreateCertificate(context.Background(), []byte("csr"), time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "chain", chain)
+
+	_, err = NewWithOptions(&Options{Endpoints: []string{srv.URL}, CertFile: certFile, KeyFile: rootFile})
+	assert.Error(t, err)
+}
@@ -1,7 +1,15 @@
 package caclient
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
 const SATLocation = "/var/run/secrets/kubernetes.io/serviceaccount/token"
@@ -10,3 +18,104 @@ func ServiceAccountToken() string {
 	token, _ := os.ReadFile(SATLocation)
 	return string(token)
 }
+
+// TokenSource provide the token to authenticate to the CA
+type TokenSource interface {
+	// Token returns the current token
+	Token() (string, error)
+	// Invalidate drop the cached token, so that it is reloaded by the next Token call
+	Invalidate()
+}
+
+// StaticToken the token never changes
+type StaticToken string
+
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+func (t StaticToken) Invalidate() {}
+
+// FileToken the token in the file, such as the projected service account token which is rotated by kubelet.
+// The file is reloaded when it changes or the token is invalidated.
+type FileToken struct {
+	path     string
+	audience string
+
+	mutex   sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// NewFileToken returns the token source of the file, audience is the expected audience of the token,
+// a warning is logged if the token is not issued for it, empty means no check
+func NewFileToken(path string, audience string) *FileToken {
+	if path == "" {
+		path = SATLocation
+	}
+	return &FileToken{path: path, audience: audience}
+}
+
+func (f *FileToken) Token() (string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", fmt.Errorf("stat token file: %w", err)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.token != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.token, nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if f.audience != "" && !hasAudience(token, f.audience) {
+		log.Warnf("[envoy-mtls] token %s is not issued for the audience %s", f.path, f.audience)
+	}
+	f.token = token
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	log.Infof("[envoy-mtls] loaded token from %s", f.path)
+	return f.token, nil
+}
+
+func (f *FileToken) Invalidate() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.token = ""
+}
+
+// hasAudience returns whether the aud claim of the JWT contains the audience
+func hasAudience(token string, audience string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	claims := struct {
+		Aud json.RawMessage `json:"aud"`
+	}{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return false
+	}
+	var auds []string
+	if err = json.Unmarshal(claims.Aud, &auds); err != nil {
+		var aud string
+		if err = json.Unmarshal(claims.Aud, &aud); err != nil {
+			return false
+		}
+		auds = []string{aud}
+	}
+	for _, aud := range auds {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
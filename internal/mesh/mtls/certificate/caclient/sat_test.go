@@ -0,0 +1,55 @@
+package caclient
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newJWT(payload string) string {
+	return "header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+}
+
+func TestFileToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	first := newJWT(`{"aud":["polaris-security"]}`)
+	assert.NoError(t, os.WriteFile(path, []byte(first+"\n"), 0600))
+	source := NewFileToken(path, "polaris-security")
+	token, err := source.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, first, token)
+
+	// kubelet 轮换 token 后重新读取
+	second := newJWT(`{"aud":"polaris-security","exp":1}`)
+	assert.NoError(t, os.WriteFile(path, []byte(second), 0600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	token, err = source.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, second, token)
+
+	// 内容变化但修改时间和大小不变时，失效后才重新读取
+	third := newJWT(`{"aud":"polaris-security","exp":2}`)
+	info, _ := os.Stat(path)
+	assert.NoError(t, os.WriteFile(path, []byte(third), 0600))
+	assert.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	token, _ = source.Token()
+	assert.Equal(t, second, token)
+	source.Invalidate()
+	token, _ = source.Token()
+	assert.Equal(t, third, token)
+
+	assert.NoError(t, os.Remove(path))
+	_, err = source.Token()
+	assert.Error(t, err)
+}
+
+func TestHasAudience(t *testing.T) {
+	assert.True(t, hasAudience(newJWT(`{"aud":["a","b"]}`), "b"))
+	assert.True(t, hasAudience(newJWT(`{"aud":"a"}`), "a"))
+	assert.False(t, hasAudience(newJWT(`{"aud":"a"}`), "b"))
+	assert.False(t, hasAudience("invalid", "a"))
+}
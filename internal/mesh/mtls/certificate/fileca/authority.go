@@ -0,0 +1,246 @@
+package fileca
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	// selfSignedLifetime the lifetime of the self signed root generated in memory
+	selfSignedLifetime = 365 * 24 * time.Hour
+	// clockSkew the issued certificates are valid from a while ago to tolerate the clock skew
+	clockSkew = time.Minute
+	// defaultTTL the lifetime of the issued certificate if the ttl is not specified
+	defaultTTL = time.Hour
+)
+
+// Config the local CA, a self signed root is generated in memory if the cert and key files are not provided,
+// which is only suitable for development and testing
+type Config struct {
+	// CertFile the PEM encoded CA certificate
+	CertFile string `yaml:"cert_file"`
+	// KeyFile the PEM encoded CA private key
+	KeyFile string `yaml:"key_file"`
+	// RootFile the PEM encoded root certificate when the CA is an intermediate, default is the CA certificate
+	RootFile string `yaml:"root_file"`
+}
+
+// Authority sign the CSRs by the local CA
+type Authority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+	rootPEM []byte
+	now     func() time.Time
+}
+
+// New returns the authority of the CA files, or the self signed one of the trust domain if no file is provided
+func New(conf *Config, trustDomain string) (*Authority, error) {
+	if conf == nil || (conf.CertFile == "" && conf.KeyFile == "") {
+		log.Warnf("[envoy-mtls] no ca file provided, use the self signed ca of %s", trustDomain)
+		return NewSelfSigned(trustDomain)
+	}
+	certPEM, err := os.ReadFile(conf.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(conf.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	rootPEM := certPEM
+	if conf.RootFile != "" {
+		if rootPEM, err = os.ReadFile(conf.RootFile); err != nil {
+			return nil, err
+		}
+	}
+	return NewAuthority(certPEM, keyPEM, rootPEM)
+}
+
+// NewAuthority returns the authority of the PEM encoded CA certificate and key
+func NewAuthority(certPEM []byte, keyPEM []byte, rootPEM []byte) (*Authority, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("no ca certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, errors.New("the certificate is not a ca")
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+		return nil, errors.New("the ca private key does not match the certificate")
+	}
+	return &Authority{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(block),
+		key:     key,
+		rootPEM: rootPEM,
+		now:     time.Now,
+	}, nil
+}
+
+// NewSelfSigned returns the authority of a self signed root generated in memory
+func NewSelfSigned(trustDomain string) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{trustDomain}, CommonName: "polaris-sidecar self signed ca"},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuthority(certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), certPEM)
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no ca private key found")
+	}
+	var key crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported ca private key %T", key)
+	}
+	return signer, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// RootPEM returns the PEM encoded root certificate
+func (a *Authority) RootPEM() []byte {
+	return a.rootPEM
+}
+
+// Sign issue the certificate of the PEM encoded CSR, the SANs of the CSR are copied to the certificate
+// and the lifetime is limited by the CA
+func (a *Authority) Sign(csrPEM []byte, ttl time.Duration) (*x509.Certificate, []byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return nil, nil, errors.New("no certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := a.now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(a.cert.NotAfter) {
+		notAfter = a.cert.NotAfter
+	}
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               csr.Subject,
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		URIs:                  csr.URIs,
+		DNSNames:              csr.DNSNames,
+		IPAddresses:           csr.IPAddresses,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, a.cert, csr.PublicKey, a.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// CreateCertificate implements manager.CSRClient, the chain ends with the root certificate
+func (a *Authority) CreateCertificate(_ context.Context, csr []byte, ttl time.Duration) (string, string, error) {
+	_, certPEM, err := a.Sign(csr, ttl)
+	if err != nil {
+		return "", "", err
+	}
+	chain := append(certPEM, a.certPEM...)
+	if !a.cert.Equal(a.rootCert()) {
+		chain = append(chain, a.rootPEM...)
+	}
+	return string(chain), string(a.rootPEM), nil
+}
+
+// rootCert returns the parsed root certificate, nil if it is invalid
+func (a *Authority) rootCert() *x509.Certificate {
+	block, _ := pem.Decode(a.rootPEM)
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
@@ -0,0 +1,126 @@
+package fileca
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+)
+
+func newCSR(t *testing.T) []byte {
+	priv, err := certificate.GenerateKey(certificate.KeyAlgorithmECDSAP256, 0)
+	assert.NoError(t, err)
+	identity := certificate.NewIdentity("default", "foo")
+	identity.DNSNames = []string{"foo.default.svc"}
+	csr, err := certificate.GenerateCSR(identity, priv)
+	assert.NoError(t, err)
+	return csr
+}
+
+func parseChain(t *testing.T, chain string) []*x509.Certificate {
+	var certs []*x509.Certificate
+	rest := []byte(chain)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		assert.NoError(t, err)
+		certs = append(certs, cert)
+	}
+}
+
+func verify(t *testing.T, chain string, root string) *x509.Certificate {
+	certs := parseChain(t, chain)
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM([]byte(root)))
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.NoError(t, err)
+	return certs[0]
+}
+
+func TestAuthority_SelfSigned(t *testing.T) {
+	ca, err := New(nil, "example.com")
+	assert.NoError(t, err)
+	chain, root, err := ca.CreateCertificate(context.Background(), newCSR(t), 10*time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, parseChain(t, chain), 2)
+	leaf := verify(t, chain, root)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/foo", leaf.URIs[0].String())
+	assert.Equal(t, []string{"foo.default.svc"}, leaf.DNSNames)
+	assert.False(t, leaf.IsCA)
+	assert.InDelta(t, (11 * time.Minute).Seconds(), leaf.NotAfter.Sub(leaf.NotBefore).Seconds(), 1)
+
+	_, _, err = ca.CreateCertificate(context.Background(), []byte("invalid"), time.Minute)
+	assert.Error(t, err)
+}
+
+func TestAuthority_Intermediate(t *testing.T) {
+	root, err := NewSelfSigned("example.com")
+	assert.NoError(t, err)
+	// 由根证书签发中间证书
+	key, err := certificate.GenerateKey(certificate.KeyAlgorithmECDSAP256, 0)
+	assert.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, root.cert, key.Public(), root.key)
+	assert.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"ca.pem":     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		"ca-key.pem": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		"root.pem":   root.RootPEM(),
+	}
+	for name, data := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+	ca, err := New(&Config{
+		CertFile: filepath.Join(dir, "ca.pem"),
+		KeyFile:  filepath.Join(dir, "ca-key.pem"),
+		RootFile: filepath.Join(dir, "root.pem"),
+	}, "example.com")
+	assert.NoError(t, err)
+
+	// the lifetime is limited by the intermediate
+	chain, rootPEM, err := ca.CreateCertificate(context.Background(), newCSR(t), 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, string(root.RootPEM()), rootPEM)
+	assert.Len(t, parseChain(t, chain), 3)
+	leaf := verify(t, chain, rootPEM)
+	assert.Equal(t, tpl.NotAfter.Unix(), leaf.NotAfter.Unix())
+
+	_, err = NewAuthority(files["root.pem"], files["ca-key.pem"], nil)
+	assert.Error(t, err)
+	_, err = NewAuthority(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}), files["ca-key.pem"], nil)
+	assert.Error(t, err)
+}
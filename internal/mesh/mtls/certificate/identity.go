@@ -0,0 +1,99 @@
+package certificate
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	SchemeSPIFFE = "spiffe"
+	// DefaultTrustDomain the trust domain of the kubernetes cluster by default
+	DefaultTrustDomain = "cluster.local"
+	// DefaultURITemplate the SPIFFE ID of the kubernetes service account
+	DefaultURITemplate = "spiffe://{trust_domain}/ns/{namespace}/sa/{service_account}"
+
+	placeholderTrustDomain    = "{trust_domain}"
+	placeholderNamespace      = "{namespace}"
+	placeholderServiceAccount = "{service_account}"
+)
+
+// Identity the identity of the workload written into the CSR
+type Identity struct {
+	// TrustDomain the SPIFFE trust domain, default is cluster.local
+	TrustDomain string
+	// URITemplate the template of the URI SAN, {trust_domain}, {namespace} and {service_account} are replaced
+	// by the values of the identity
+	URITemplate    string
+	Namespace      string
+	ServiceAccount string
+	// DNSNames the extra DNS SANs
+	DNSNames []string
+	// IPAddresses the extra IP SANs
+	IPAddresses []net.IP
+}
+
+// NewIdentity returns the identity of the service account with the default trust domain and URI template
+func NewIdentity(ns string, sa string) *Identity {
+	return &Identity{
+		TrustDomain:    DefaultTrustDomain,
+		URITemplate:    DefaultURITemplate,
+		Namespace:      ns,
+		ServiceAccount: sa,
+	}
+}
+
+func (id *Identity) trustDomain() string {
+	if id.TrustDomain == "" {
+		return DefaultTrustDomain
+	}
+	return id.TrustDomain
+}
+
+func (id *Identity) uriTemplate() string {
+	if id.URITemplate == "" {
+		return DefaultURITemplate
+	}
+	return id.URITemplate
+}
+
+// RequiresServiceAccount returns whether the URI SAN contains the namespace or the service account,
+// e.g. a VM identity may only use the trust domain and a fixed path
+func (id *Identity) RequiresServiceAccount() bool {
+	template := id.uriTemplate()
+	return strings.Contains(template, placeholderNamespace) || strings.Contains(template, placeholderServiceAccount)
+}
+
+// URI returns the URI SAN of the identity, which is the SPIFFE ID with the default template
+func (id *Identity) URI() (*url.URL, error) {
+	replacer := strings.NewReplacer(placeholderTrustDomain, id.trustDomain(), placeholderNamespace, id.Namespace,
+		placeholderServiceAccount, id.ServiceAccount)
+	uri, err := url.Parse(replacer.Replace(id.uriTemplate()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri template %s: %w", id.URITemplate, err)
+	}
+	if uri.Scheme == "" || uri.Host == "" {
+		return nil, fmt.Errorf("uri template %s should contain the scheme and the host", id.URITemplate)
+	}
+	return uri, nil
+}
+
+// Validate check that the URI SAN of the identity can be made
+func (id *Identity) Validate() error {
+	_, err := id.URI()
+	return err
+}
+
+// ParseIPAddresses parse the IP SANs, and returns an error if any of them is invalid
+func ParseIPAddresses(values []string) ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(values))
+	for _, value := range values {
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %s", value)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
@@ -0,0 +1,58 @@
+package certificate
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentity_URI(t *testing.T) {
+	identity := &Identity{Namespace: "default", ServiceAccount: "foo"}
+	uri, err := identity.URI()
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/foo", uri.String())
+	assert.True(t, identity.RequiresServiceAccount())
+
+	identity.TrustDomain = "east.example.com"
+	uri, err = identity.URI()
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://east.example.com/ns/default/sa/foo", uri.String())
+
+	vm := &Identity{TrustDomain: "example.com", URITemplate: "spiffe://{trust_domain}/vm/billing"}
+	uri, err = vm.URI()
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://example.com/vm/billing", uri.String())
+	assert.False(t, vm.RequiresServiceAccount())
+
+	assert.Error(t, (&Identity{URITemplate: "/ns/{namespace}"}).Validate())
+}
+
+func TestGenerateCSR_SANs(t *testing.T) {
+	priv, err := GenerateKey(KeyAlgorithmECDSAP256, 0)
+	assert.NoError(t, err)
+	ips, err := ParseIPAddresses([]string{"10.0.0.1", " ::1"})
+	assert.NoError(t, err)
+	identity := &Identity{
+		TrustDomain:    "east.example.com",
+		Namespace:      "default",
+		ServiceAccount: "foo",
+		DNSNames:       []string{"foo.default.svc"},
+		IPAddresses:    ips,
+	}
+	csrPem, err := GenerateCSR(identity, priv)
+	assert.NoError(t, err)
+	block, _ := pem.Decode(csrPem)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"east.example.com"}, csr.Subject.Organization)
+	assert.Equal(t, "spiffe://east.example.com/ns/default/sa/foo", csr.URIs[0].String())
+	assert.Equal(t, []string{"foo.default.svc"}, csr.DNSNames)
+	assert.True(t, net.ParseIP("10.0.0.1").Equal(csr.IPAddresses[0]))
+	assert.True(t, net.IPv6loopback.Equal(csr.IPAddresses[1]))
+
+	_, err = ParseIPAddresses([]string{"foo"})
+	assert.Error(t, err)
+}
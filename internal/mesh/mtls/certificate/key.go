@@ -0,0 +1,81 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package certificate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+)
+
+const (
+	KeyAlgorithmRSA       = "RSA"
+	KeyAlgorithmECDSAP256 = "ECDSA_P256"
+	KeyAlgorithmECDSAP384 = "ECDSA_P384"
+	KeyAlgorithmEd25519   = "ED25519"
+)
+
+// NormalizeKeyAlgorithm returns the upper case algorithm, and an error if it is not supported
+func NormalizeKeyAlgorithm(algorithm string) (string, error) {
+	algorithm = strings.ToUpper(algorithm)
+	switch algorithm {
+	case KeyAlgorithmRSA, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmEd25519:
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("unsupported key algorithm %s, should be one of %s, %s, %s, %s", algorithm,
+		KeyAlgorithmRSA, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmEd25519)
+}
+
+// GenerateKey generate the private key of the algorithm, rsaBits is only used by RSA
+func GenerateKey(algorithm string, rsaBits int) (crypto.Signer, error) {
+	switch algorithm {
+	case KeyAlgorithmRSA:
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %s", algorithm)
+}
+
+// KeyAlgorithm returns the algorithm of the private key, or empty if it is not supported
+func KeyAlgorithm(priv crypto.PrivateKey) string {
+	switch key := priv.(type) {
+	case *rsa.PrivateKey:
+		return KeyAlgorithmRSA
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return KeyAlgorithmECDSAP256
+		case elliptic.P384():
+			return KeyAlgorithmECDSAP384
+		}
+	case ed25519.PrivateKey:
+		return KeyAlgorithmEd25519
+	}
+	return ""
+}
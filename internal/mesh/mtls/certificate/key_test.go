@@ -0,0 +1,57 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package certificate
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCSR_KeyAlgorithms(t *testing.T) {
+	expects := map[string]x509.SignatureAlgorithm{
+		KeyAlgorithmRSA:       x509.SHA256WithRSA,
+		KeyAlgorithmECDSAP256: x509.ECDSAWithSHA256,
+		KeyAlgorithmECDSAP384: x509.ECDSAWithSHA384,
+		KeyAlgorithmEd25519:   x509.PureEd25519,
+	}
+	for algorithm, signatureAlgorithm := range expects {
+		priv, err := GenerateKey(algorithm, 2048)
+		assert.NoError(t, err, algorithm)
+		assert.Equal(t, algorithm, KeyAlgorithm(priv))
+
+		csrPem, err := GenerateCSR(NewIdentity("default", "foo"), priv)
+		assert.NoError(t, err, algorithm)
+		block, _ := pem.Decode(csrPem)
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		assert.NoError(t, err, algorithm)
+		assert.NoError(t, csr.CheckSignature(), algorithm)
+		assert.Equal(t, signatureAlgorithm, csr.SignatureAlgorithm, algorithm)
+		assert.Equal(t, "spiffe://cluster.local/ns/default/sa/foo", csr.URIs[0].String())
+	}
+}
+
+func TestNormalizeKeyAlgorithm(t *testing.T) {
+	algorithm, err := NormalizeKeyAlgorithm("ecdsa_p256")
+	assert.NoError(t, err)
+	assert.Equal(t, KeyAlgorithmECDSAP256, algorithm)
+	_, err = NormalizeKeyAlgorithm("DSA")
+	assert.Error(t, err)
+}
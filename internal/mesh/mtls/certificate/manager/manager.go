@@ -2,8 +2,6 @@ package manager
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"time"
@@ -13,25 +11,25 @@ import (
 )
 
 type manager struct {
-	namespace      string
-	serviceAccount string
-	bits           int
-	ttl            time.Duration
-	cli            CSRClient
+	identity     *certificate.Identity
+	keyAlgorithm string
+	bits         int
+	ttl          time.Duration
+	cli          CSRClient
 }
 
-func NewManager(namespace string,
-	serviceAccount string,
+func NewManager(identity *certificate.Identity,
+	keyAlgorithm string,
 	bits int,
 	ttl time.Duration,
 	cli CSRClient,
 ) Manager {
 	return &manager{
-		namespace:      namespace,
-		serviceAccount: serviceAccount,
-		bits:           bits,
-		ttl:            ttl,
-		cli:            cli,
+		identity:     identity,
+		keyAlgorithm: keyAlgorithm,
+		bits:         bits,
+		ttl:          ttl,
+		cli:          cli,
 	}
 }
 
@@ -41,39 +39,38 @@ const (
 	PemRSAPrivateKeyType = "RSA PRIVATE KEY"
 )
 
-const (
-	SchemeSPIFFE = "spiffe"
-	TrustDomain  = "cluster.local"
-)
-
 func (m *manager) GetBundle(ctx context.Context) (*certificate.Bundle, error) {
-	priv, err := rsa.GenerateKey(rand.Reader, m.bits)
+	priv, err := certificate.GenerateKey(m.keyAlgorithm, m.bits)
 	if err != nil {
-		log.Errorf("rsa generate key failed: %s", err)
+		log.Errorf("%s generate key failed: %s", m.keyAlgorithm, err)
 		return nil, err
 	}
 	// generate CSR using spiffe style
-	csr, err := certificate.GenerateCSR(m.namespace, m.serviceAccount, priv)
+	csr, err := certificate.GenerateCSR(m.identity, priv)
 	if err != nil {
 		return nil, err
 	}
-	log.Infof("generate CSR for %s/%s", m.namespace, m.serviceAccount)
+	log.Infof("generate CSR for %s/%s", m.identity.Namespace, m.identity.ServiceAccount)
 
 	chain, rootCA, err := m.cli.CreateCertificate(ctx, csr, m.ttl)
 	if err != nil {
 		log.Errorf("signed certificate failed: %s", err)
 		return nil, err
 	}
-	log.Infof("signed certificate for %s/%s", m.namespace, m.serviceAccount)
+	log.Infof("signed certificate for %s/%s", m.identity.Namespace, m.identity.ServiceAccount)
 
 	// the last cert in the chain must be the rootCA
 
-	// rsa key should always got a success result
-	PKCS8Priv, _ := x509.MarshalPKCS8PrivateKey(priv)
+	PKCS8Priv, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		log.Errorf("marshal %s private key failed: %s", m.keyAlgorithm, err)
+		return nil, err
+	}
 
 	return &certificate.Bundle{
-		ROOTCA:    []byte(rootCA),
-		CertChain: []byte(chain),
-		PrivKey:   pem.EncodeToMemory(&pem.Block{Type: PemPrivateKeyType, Bytes: PKCS8Priv}),
+		ROOTCA:       []byte(rootCA),
+		CertChain:    []byte(chain),
+		PrivKey:      pem.EncodeToMemory(&pem.Block{Type: PemPrivateKeyType, Bytes: PKCS8Priv}),
+		KeyAlgorithm: m.keyAlgorithm,
 	}, nil
 }
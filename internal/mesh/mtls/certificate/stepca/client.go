@@ -0,0 +1,143 @@
+package stepca
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/caclient"
+)
+
+const signPath = "/1.0/sign"
+
+// Config the step-ca style CA, which signs the CSR authorized by the one time token of the provisioner
+type Config struct {
+	// Endpoint the address of the CA, e.g. https://ca.example.com:9000
+	Endpoint string `yaml:"endpoint"`
+	// RootFile the PEM encoded root certificate, which verifies the CA server and is served as the root
+	RootFile string `yaml:"root_file"`
+	// TokenFile the file of the provisioner token, it is read on each request so that it can be refreshed
+	// by the other process, default is the token of the agent
+	TokenFile string `yaml:"token_file"`
+}
+
+type Client struct {
+	client    *http.Client
+	endpoint  string
+	tokenFile string
+	// tokens the token of the agent, used if no token file is configured
+	tokens  caclient.TokenSource
+	rootPEM []byte
+}
+
+func New(conf *Config, tokens caclient.TokenSource) (*Client, error) {
+	if conf == nil || conf.Endpoint == "" {
+		return nil, errors.New("no step-ca endpoint provided")
+	}
+	if conf.RootFile == "" {
+		return nil, errors.New("no step-ca root file provided")
+	}
+	rootPEM, err := os.ReadFile(conf.RootFile)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := caclient.NewHTTPClient(conf.RootFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithHTTPClient(conf, rootPEM, cli, tokens), nil
+}
+
+// NewWithHTTPClient returns the client using the http client and trusting the root
+func NewWithHTTPClient(conf *Config, rootPEM []byte, cli *http.Client, tokens caclient.TokenSource) *Client {
+	return &Client{
+		client:    cli,
+		endpoint:  strings.TrimSuffix(conf.Endpoint, "/"),
+		tokenFile: conf.TokenFile,
+		tokens:    tokens,
+		rootPEM:   rootPEM,
+	}
+}
+
+// token returns the provisioner token from the token file, or the token of the agent
+func (c *Client) token() (string, error) {
+	if c.tokenFile == "" {
+		return c.tokens.Token()
+	}
+	token, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// RootPEM returns the PEM encoded root certificate
+func (c *Client) RootPEM() []byte {
+	return c.rootPEM
+}
+
+type SignRequest struct {
+	CSR      string `json:"csr"`
+	OTT      string `json:"ott"`
+	NotAfter string `json:"notAfter,omitempty"`
+}
+
+type SignResponse struct {
+	Crt       string   `json:"crt"`
+	CA        string   `json:"ca"`
+	CertChain []string `json:"certChain"`
+	// if signing failed, error message will be wrapped in `Message`
+	Message string `json:"message"`
+}
+
+// CreateCertificate implements manager.CSRClient, the chain ends with the root certificate
+func (c *Client) CreateCertificate(ctx context.Context, csr []byte, ttl time.Duration) (string, string, error) {
+	token, err := c.token()
+	if err != nil {
+		return "", "", fmt.Errorf("read provisioner token: %w", err)
+	}
+	payload, err := json.Marshal(&SignRequest{
+		CSR:      string(csr),
+		OTT:      token,
+		NotAfter: ttl.String(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+signPath, bytes.NewReader(payload))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	sr := &SignResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(sr); err != nil {
+		return "", "", fmt.Errorf("decode sign response with status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", "", fmt.Errorf("sign certificate failed with status %d: %s", resp.StatusCode, sr.Message)
+	}
+	chain := sr.CertChain
+	if len(chain) == 0 {
+		chain = []string{sr.Crt, sr.CA}
+	}
+	var buf strings.Builder
+	for _, cert := range chain {
+		buf.WriteString(cert)
+		if !strings.HasSuffix(cert, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	buf.Write(c.rootPEM)
+	return buf.String(), string(c.rootPEM), nil
+}
@@ -0,0 +1,69 @@
+package stepca
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/caclient"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/fileca"
+)
+
+func TestClient_CreateCertificate(t *testing.T) {
+	ca, err := fileca.NewSelfSigned("example.com")
+	assert.NoError(t, err)
+	// step-ca 的本地替身，使用 fileca 签发证书
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, signPath, r.URL.Path)
+		req := &SignRequest{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		if req.OTT != "ott" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(&SignResponse{Message: "invalid token"})
+			return
+		}
+		ttl, err := time.ParseDuration(req.NotAfter)
+		assert.NoError(t, err)
+		_, crt, err := ca.Sign([]byte(req.CSR), ttl)
+		assert.NoError(t, err)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(&SignResponse{Crt: string(crt), CertChain: []string{string(crt)}})
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("ott\n"), 0600))
+	cli := NewWithHTTPClient(&Config{Endpoint: srv.URL + "/", TokenFile: tokenFile}, ca.RootPEM(), srv.Client(),
+		caclient.StaticToken("sat"))
+
+	priv, err := certificate.GenerateKey(certificate.KeyAlgorithmECDSAP256, 0)
+	assert.NoError(t, err)
+	csr, err := certificate.GenerateCSR(certificate.NewIdentity("default", "foo"), priv)
+	assert.NoError(t, err)
+	chain, root, err := cli.CreateCertificate(context.Background(), csr, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ca.RootPEM()), root)
+	assert.True(t, strings.HasSuffix(chain, root))
+	leaf, err := certificate.ParseLeaf([]byte(chain))
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/foo", leaf.URIs[0].String())
+
+	// 令牌文件每次请求都重新读取
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("expired"), 0600))
+	_, _, err = cli.CreateCertificate(context.Background(), csr, time.Hour)
+	assert.ErrorContains(t, err, "invalid token")
+
+	// 未配置令牌文件时使用 agent 的 token
+	cli = NewWithHTTPClient(&Config{Endpoint: srv.URL}, ca.RootPEM(), srv.Client(), caclient.StaticToken("ott"))
+	_, _, err = cli.CreateCertificate(context.Background(), csr, time.Hour)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,169 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	KeyFileName       = "key.pem"
+	CertChainFileName = "cert-chain.pem"
+	RootCertFileName  = "root-cert.pem"
+	// CASourceFileName the file recording the CA which issued the cached bundle
+	CASourceFileName = "ca-source"
+)
+
+// SaveBundle write the key, chain and root to the dir and record the CA source, the files are replaced atomically
+// together and only accessible by the owner
+func SaveBundle(dir string, bundle *Bundle, source string) error {
+	return writeFiles(dir, append(bundleFiles(bundle), bundleFile{name: CASourceFileName, data: []byte(source)}), 0600)
+}
+
+// WriteBundle write the key, chain and root to the dir with the file permission, the files are replaced atomically
+// together, so that the readers never see a key and a chain of different versions.
+// The dir is created with the search permission of the readers.
+func WriteBundle(dir string, bundle *Bundle, perm os.FileMode) error {
+	return writeFiles(dir, bundleFiles(bundle), perm)
+}
+
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+func bundleFiles(bundle *Bundle) []bundleFile {
+	return []bundleFile{
+		{name: RootCertFileName, data: bundle.ROOTCA},
+		{name: CertChainFileName, data: bundle.CertChain},
+		{name: KeyFileName, data: bundle.PrivKey},
+	}
+}
+
+const (
+	// dataDirName the symlink to the versioned dir of the current files
+	dataDirName = "..data"
+	// versionDirPrefix the prefix of the versioned dirs
+	versionDirPrefix = "..bundle_"
+)
+
+// writeFiles write the files to a new versioned dir and swap the data symlink to it, like the AtomicWriter of
+// kubernetes volumes. Each file in the dir is a symlink to the same name under the data symlink.
+func writeFiles(dir string, files []bundleFile, perm os.FileMode) error {
+	dirPerm := perm | (perm&0444)>>2
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return err
+	}
+	versionDir, err := os.MkdirTemp(dir, versionDirPrefix)
+	if err != nil {
+		return err
+	}
+	if err = writeVersion(versionDir, files, perm, dirPerm); err != nil {
+		_ = os.RemoveAll(versionDir)
+		return err
+	}
+	oldVersion, _ := os.Readlink(filepath.Join(dir, dataDirName))
+	if err = replaceSymlink(filepath.Base(versionDir), filepath.Join(dir, dataDirName)); err != nil {
+		_ = os.RemoveAll(versionDir)
+		return err
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		target := filepath.Join(dataDirName, file.name)
+		if current, err := os.Readlink(path); err == nil && current == target {
+			continue
+		}
+		// 首次写入或由旧版本的普通文件升级为符号链接
+		if err = replaceSymlink(target, path); err != nil {
+			return err
+		}
+	}
+	if len(oldVersion) > 0 && oldVersion != filepath.Base(versionDir) {
+		if err = os.RemoveAll(filepath.Join(dir, oldVersion)); err != nil {
+			log.Warnf("[envoy-mtls] remove the old certificate files %s failed: %v", oldVersion, err)
+		}
+	}
+	return nil
+}
+
+// writeVersion write the files to the versioned dir which is not visible to the readers yet
+func writeVersion(versionDir string, files []bundleFile, perm os.FileMode, dirPerm os.FileMode) error {
+	if err := os.Chmod(versionDir, dirPerm); err != nil {
+		return err
+	}
+	for _, file := range files {
+		f, err := os.OpenFile(filepath.Join(versionDir, file.name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			return err
+		}
+		if err = f.Chmod(perm); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if _, err = f.Write(file.data); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err = f.Sync(); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceSymlink create the symlink to the target and rename it to the path atomically
+func replaceSymlink(target string, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LoadBundle read the bundle and the CA source saved by SaveBundle, and check that the key matches
+// the leaf certificate. The source is empty if it is not recorded.
+func LoadBundle(dir string) (*Bundle, string, error) {
+	bundle := &Bundle{}
+	var err error
+	if bundle.ROOTCA, err = os.ReadFile(filepath.Join(dir, RootCertFileName)); err != nil {
+		return nil, "", err
+	}
+	if bundle.CertChain, err = os.ReadFile(filepath.Join(dir, CertChainFileName)); err != nil {
+		return nil, "", err
+	}
+	if bundle.PrivKey, err = os.ReadFile(filepath.Join(dir, KeyFileName)); err != nil {
+		return nil, "", err
+	}
+	if _, err = tls.X509KeyPair(bundle.CertChain, bundle.PrivKey); err != nil {
+		return nil, "", fmt.Errorf("key does not match the certificate chain: %w", err)
+	}
+	block, _ := pem.Decode(bundle.PrivKey)
+	if block == nil {
+		return nil, "", errors.New("no private key found")
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+	bundle.KeyAlgorithm = KeyAlgorithm(priv)
+	source, err := os.ReadFile(filepath.Join(dir, CASourceFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	return bundle, string(source), nil
+}
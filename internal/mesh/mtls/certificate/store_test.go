@@ -0,0 +1,116 @@
+package certificate
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBundle returns a bundle with a self signed leaf certificate
+func newTestBundle(t *testing.T, algorithm string) *Bundle {
+	priv, err := GenerateKey(algorithm, 2048)
+	assert.NoError(t, err)
+	uri, err := NewIdentity("default", "foo").URI()
+	assert.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{DefaultTrustDomain}},
+		URIs:         []*url.URL{uri},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, priv.Public(), priv)
+	assert.NoError(t, err)
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	assert.NoError(t, err)
+	return &Bundle{
+		ROOTCA:       cert,
+		CertChain:    cert,
+		PrivKey:      pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		KeyAlgorithm: algorithm,
+	}
+}
+
+func TestSaveBundle(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mtls")
+	bundle := newTestBundle(t, KeyAlgorithmECDSAP256)
+	assert.NoError(t, SaveBundle(dir, bundle, "polaris:https://ca:8888"))
+	for _, name := range []string{KeyFileName, CertChainFileName, RootCertFileName} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	// 4 个文件的符号链接、..data 及一个版本目录
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 6)
+
+	loaded, source, err := LoadBundle(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, bundle, loaded)
+	assert.Equal(t, "polaris:https://ca:8888", source)
+	assert.NoError(t, VerifyChain(loaded, time.Now()))
+	assert.Error(t, VerifyChain(loaded, time.Now().Add(2*time.Hour)))
+	assert.Error(t, VerifyChain(&Bundle{ROOTCA: newTestBundle(t, KeyAlgorithmEd25519).ROOTCA,
+		CertChain: loaded.CertChain}, time.Now()))
+	leaf, err := ParseLeaf(loaded.CertChain)
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/foo", leaf.URIs[0].String())
+
+	// 再次写入时切换到新的版本目录并删除旧版本
+	oldVersion, err := os.Readlink(filepath.Join(dir, dataDirName))
+	assert.NoError(t, err)
+	assert.NoError(t, SaveBundle(dir, bundle, "polaris:https://ca:8888"))
+	version, err := os.Readlink(filepath.Join(dir, dataDirName))
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldVersion, version)
+	_, err = os.Stat(filepath.Join(dir, oldVersion))
+	assert.True(t, os.IsNotExist(err))
+	entries, _ = os.ReadDir(dir)
+	assert.Len(t, entries, 6)
+
+	// 私钥与证书不匹配
+	other := newTestBundle(t, KeyAlgorithmEd25519)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, KeyFileName), other.PrivKey, 0600))
+	_, _, err = LoadBundle(dir)
+	assert.Error(t, err)
+
+	_, _, err = LoadBundle(filepath.Join(dir, "absent"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteBundle_FileMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	bundle := newTestBundle(t, KeyAlgorithmEd25519)
+	// 旧版本写入的普通文件被替换为符号链接
+	assert.NoError(t, os.MkdirAll(dir, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, KeyFileName), []byte("old"), 0640))
+	assert.NoError(t, WriteBundle(dir, bundle, 0640))
+	linkInfo, err := os.Lstat(filepath.Join(dir, KeyFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, linkInfo.Mode().Type())
+	info, err := os.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm()&^umask(t))
+	info, err = os.Stat(filepath.Join(dir, KeyFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+// umask returns the permission bits masked on creating directories
+func umask(t *testing.T) os.FileMode {
+	dir := filepath.Join(t.TempDir(), "umask")
+	assert.NoError(t, os.Mkdir(dir, 0777))
+	info, err := os.Stat(dir)
+	assert.NoError(t, err)
+	return 0777 &^ info.Mode().Perm()
+}
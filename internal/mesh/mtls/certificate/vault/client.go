@@ -0,0 +1,235 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/caclient"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	DefaultMount               = "pki"
+	DefaultKubernetesAuthMount = "kubernetes"
+
+	tokenHeader = "X-Vault-Token"
+	envToken    = "VAULT_TOKEN"
+)
+
+// Config the Vault PKI secrets engine, the role should allow the URI SANs of the workloads and
+// set require_cn=false, since the CSR has no common name
+type Config struct {
+	// Address the address of vault, e.g. https://vault.example.com:8200
+	Address string `yaml:"address"`
+	// Mount the path of the PKI secrets engine, default is pki
+	Mount string `yaml:"mount"`
+	// Role the role to sign the certificates
+	Role string `yaml:"role"`
+	// RootFile the PEM encoded roots to verify the vault server, the system roots are used if empty
+	RootFile string `yaml:"root_file"`
+	// TokenFile the file of the vault token, default is the VAULT_TOKEN env
+	TokenFile string `yaml:"token_file"`
+	// KubernetesAuthRole login by the kubernetes auth method with the token of the agent
+	// if no vault token is provided
+	KubernetesAuthRole string `yaml:"kubernetes_auth_role"`
+	// KubernetesAuthMount the path of the kubernetes auth method, default is kubernetes
+	KubernetesAuthMount string `yaml:"kubernetes_auth_mount"`
+}
+
+type Client struct {
+	client *http.Client
+	conf   Config
+	// tokens the token of the agent to login by the kubernetes auth method
+	tokens caclient.TokenSource
+
+	mutex sync.Mutex
+	// token the vault token, it is reset when rejected if it is got by login
+	token string
+}
+
+func New(conf *Config, tokens caclient.TokenSource) (*Client, error) {
+	if conf == nil || conf.Address == "" || conf.Role == "" {
+		return nil, errors.New("vault address and role should be provided")
+	}
+	cli := &http.Client{Timeout: caclient.DefaultTimeout}
+	if conf.RootFile != "" {
+		var err error
+		if cli, err = caclient.NewHTTPClient(conf.RootFile); err != nil {
+			return nil, err
+		}
+	}
+	return NewWithHTTPClient(conf, cli, tokens), nil
+}
+
+// NewWithHTTPClient returns the client using the http client
+func NewWithHTTPClient(conf *Config, cli *http.Client, tokens caclient.TokenSource) *Client {
+	c := &Client{client: cli, conf: *conf, tokens: tokens}
+	c.conf.Address = strings.TrimSuffix(c.conf.Address, "/")
+	if c.conf.Mount == "" {
+		c.conf.Mount = DefaultMount
+	}
+	if c.conf.KubernetesAuthMount == "" {
+		c.conf.KubernetesAuthMount = DefaultKubernetesAuthMount
+	}
+	return c
+}
+
+type SignRequest struct {
+	CSR    string `json:"csr"`
+	TTL    string `json:"ttl"`
+	Format string `json:"format"`
+}
+
+type LoginRequest struct {
+	Role string `json:"role"`
+	JWT  string `json:"jwt"`
+}
+
+type Response struct {
+	Data struct {
+		Certificate string   `json:"certificate"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+	} `json:"data"`
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	// if the request failed, error messages will be wrapped in `Errors`
+	Errors []string `json:"errors"`
+}
+
+// statusError the error response of vault
+type statusError struct {
+	status int
+	errors []string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("vault responds status %d: %s", e.status, strings.Join(e.errors, "; "))
+}
+
+// CreateCertificate implements manager.CSRClient, the chain ends with the root certificate
+func (c *Client) CreateCertificate(ctx context.Context, csr []byte, ttl time.Duration) (string, string, error) {
+	payload := &SignRequest{
+		CSR:    string(csr),
+		TTL:    fmt.Sprintf("%ds", int64(ttl.Seconds())),
+		Format: "pem",
+	}
+	resp, err := c.sign(ctx, payload)
+	var se *statusError
+	if errors.As(err, &se) && se.status == http.StatusForbidden && c.resetLoginToken() {
+		// 登录获取的 token 过期后重新登录一次
+		log.Warnf("[envoy-mtls] vault token is rejected, login again")
+		resp, err = c.sign(ctx, payload)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	chain := resp.Data.CAChain
+	if len(chain) == 0 {
+		chain = []string{resp.Data.IssuingCA}
+	}
+	var buf strings.Builder
+	for _, cert := range append([]string{resp.Data.Certificate}, chain...) {
+		buf.WriteString(cert)
+		if !strings.HasSuffix(cert, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String(), chain[len(chain)-1], nil
+}
+
+func (c *Client) sign(ctx context.Context, payload *SignRequest) (*Response, error) {
+	token, err := c.vaultToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, fmt.Sprintf("/v1/%s/sign/%s", c.conf.Mount, c.conf.Role), token, payload)
+}
+
+// vaultToken returns the token from the file or env, or login by the kubernetes auth method
+func (c *Client) vaultToken(ctx context.Context) (string, error) {
+	if c.conf.TokenFile != "" {
+		token, err := os.ReadFile(c.conf.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("read vault token: %w", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+	if token := os.Getenv(envToken); token != "" {
+		return token, nil
+	}
+	if c.conf.KubernetesAuthRole == "" {
+		return "", errors.New("no vault token or kubernetes auth role provided")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" {
+		return c.token, nil
+	}
+	jwt, err := c.tokens.Token()
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, fmt.Sprintf("/v1/auth/%s/login", c.conf.KubernetesAuthMount), "", &LoginRequest{
+		Role: c.conf.KubernetesAuthRole,
+		JWT:  jwt,
+	})
+	if err != nil {
+		return "", fmt.Errorf("vault kubernetes login: %w", err)
+	}
+	c.token = resp.Auth.ClientToken
+	return c.token, nil
+}
+
+// resetLoginToken reset the token got by login, returns false if the token is not got by login
+func (c *Client) resetLoginToken() bool {
+	if c.conf.TokenFile != "" || os.Getenv(envToken) != "" {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token == "" {
+		return false
+	}
+	c.token = ""
+	// 重新登录时同时重新读取 token 文件
+	c.tokens.Invalidate()
+	return true
+}
+
+func (c *Client) do(ctx context.Context, path string, token string, payload interface{}) (*Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.conf.Address+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set(tokenHeader, token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	vr := &Response{}
+	if err = json.NewDecoder(resp.Body).Decode(vr); err != nil {
+		return nil, fmt.Errorf("decode vault response with status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{status: resp.StatusCode, errors: vr.Errors}
+	}
+	return vr, nil
+}
@@ -0,0 +1,84 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/caclient"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/fileca"
+)
+
+func TestClient_CreateCertificate(t *testing.T) {
+	ca, err := fileca.NewSelfSigned("example.com")
+	assert.NoError(t, err)
+	logins := 0
+	validToken := ""
+	// vault PKI 的本地替身，使用 fileca 签发证书
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := &Response{}
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			req := &LoginRequest{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(req))
+			assert.Equal(t, "sidecar", req.Role)
+			assert.Equal(t, "jwt", req.JWT)
+			logins++
+			resp.Auth.ClientToken = "token-" + strings.Repeat("x", logins)
+			if logins > 1 {
+				validToken = resp.Auth.ClientToken
+			}
+		case "/v1/pki_int/sign/workload":
+			if r.Header.Get(tokenHeader) != validToken {
+				w.WriteHeader(http.StatusForbidden)
+				resp.Errors = []string{"permission denied"}
+				break
+			}
+			req := &SignRequest{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(req))
+			assert.Equal(t, "3600s", req.TTL)
+			_, crt, err := ca.Sign([]byte(req.CSR), time.Hour)
+			assert.NoError(t, err)
+			resp.Data.Certificate = string(crt)
+			resp.Data.IssuingCA = string(ca.RootPEM())
+			resp.Data.CAChain = []string{string(ca.RootPEM())}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	t.Setenv(envToken, "")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("jwt\n"), 0600))
+	cli := NewWithHTTPClient(&Config{Address: srv.URL, Mount: "pki_int", Role: "workload",
+		KubernetesAuthRole: "sidecar"}, srv.Client(), caclient.NewFileToken(tokenFile, ""))
+
+	priv, err := certificate.GenerateKey(certificate.KeyAlgorithmECDSAP256, 0)
+	assert.NoError(t, err)
+	csr, err := certificate.GenerateCSR(certificate.NewIdentity("default", "foo"), priv)
+	assert.NoError(t, err)
+	// 第一次登录获取的 token 被拒绝后重新登录并重试
+	chain, root, err := cli.CreateCertificate(context.Background(), csr, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, logins)
+	assert.Equal(t, string(ca.RootPEM()), root)
+	leaf, err := certificate.ParseLeaf([]byte(chain))
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/foo", leaf.URIs[0].String())
+
+	t.Setenv(envToken, "static")
+	_, _, err = cli.CreateCertificate(context.Background(), csr, time.Hour)
+	assert.ErrorContains(t, err, "permission denied")
+	assert.Equal(t, 2, logins)
+}
@@ -0,0 +1,122 @@
+package mtls
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	manager2 "github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/manager"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/rotator"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/sds"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+func (a *Agent) newRotator(name string) *rotator.Rotator {
+	return rotator.New(rotator.Config{
+		Name:           name,
+		Period:         a.opt.RotatePeriod,
+		RetryDelay:     a.opt.FailedRetryDelay,
+		MaxRetryDelay:  a.opt.MaxFailedRetryDelay,
+		RenewFraction:  a.opt.RotateFraction,
+		Jitter:         a.opt.RotateJitter,
+		AlertThreshold: a.opt.ExpiryAlertThreshold,
+	})
+}
+
+func (a *Agent) newManager(identity *certificate.Identity) manager2.Manager {
+	return manager2.NewManager(identity, a.opt.KeyAlgorithm, a.opt.RSAKeyBits, a.opt.TTL, a.client)
+}
+
+// initTrust load the trust bundles of the federated trust domains, and set the validation contexts
+func (a *Agent) initTrust() error {
+	bundles := make(map[string][]byte, len(a.opt.TrustBundles))
+	for name, path := range a.opt.TrustBundles {
+		if name == sds.RootCASecretName || name == sds.DefaultSecretName {
+			return fmt.Errorf("trust bundle name %s is reserved", name)
+		}
+		roots, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read trust bundle %s: %w", name, err)
+		}
+		bundles[name] = roots
+	}
+	a.trustBundles = bundles
+	if len(bundles) > 0 {
+		a.sds.SetTrustBundles(context.Background(), bundles)
+	}
+	if len(a.opt.ValidationContexts) > 0 {
+		a.sds.SetValidationContexts(context.Background(), a.opt.ValidationContexts)
+	}
+	return nil
+}
+
+// resolveIdentity returns the identity of the secret name, only the identity of the agent and the configured
+// identities are issued, by their names or SPIFFE IDs
+func (a *Agent) resolveIdentity(name string) (*certificate.Identity, bool) {
+	if identity := a.opt.identity(); matchURI(identity, name) {
+		return identity, true
+	}
+	for _, configured := range a.opt.Identities {
+		identity := a.opt.identityOf(configured.Namespace, configured.ServiceAccount)
+		if configured.Name == name || matchURI(identity, name) {
+			return identity, true
+		}
+	}
+	return nil, false
+}
+
+// matchURI returns whether the name is the SPIFFE ID of the identity
+func matchURI(identity *certificate.Identity, name string) bool {
+	uri, err := identity.URI()
+	return err == nil && uri.String() == name
+}
+
+// generateIdentity issue the certificate of the identity requested by envoy, and keep rotating it until the
+// agent stops
+func (a *Agent) generateIdentity(ctx context.Context, name string) error {
+	if name == sds.DefaultSecretName || name == sds.RootCASecretName {
+		// 由默认的 rotator 生成
+		return nil
+	}
+	identity, ok := a.resolveIdentity(name)
+	if !ok {
+		return fmt.Errorf("unknown secret %s", name)
+	}
+	a.identityMutex.Lock()
+	if _, exist := a.identities[name]; exist {
+		a.identityMutex.Unlock()
+		return nil
+	}
+	a.identities[name] = struct{}{}
+	a.identityMutex.Unlock()
+
+	certManager := a.newManager(identity)
+	rotate := func(ctx context.Context) (*x509.Certificate, error) {
+		bundle, err := certManager.GetBundle(ctx)
+		if err != nil {
+			log.Errorf("[envoy-mtls] get certificate bundle of %s failed: %v", name, err)
+			return nil, err
+		}
+		a.sds.UpdateIdentity(ctx, name, *bundle)
+		leaf, err := certificate.ParseLeaf(bundle.CertChain)
+		if err != nil {
+			log.Warnf("[envoy-mtls] parse certificate of %s failed, rotate by period: %v", name, err)
+			return nil, nil
+		}
+		return leaf, nil
+	}
+	leaf, err := rotate(ctx)
+	if err != nil {
+		a.identityMutex.Lock()
+		delete(a.identities, name)
+		a.identityMutex.Unlock()
+		return err
+	}
+	log.Infof("[envoy-mtls] generated identity %s for %s/%s on demand", name, identity.Namespace, identity.ServiceAccount)
+	go func() {
+		_ = a.newRotator(name).Run(a.runCtx, leaf, rotate)
+	}()
+	return nil
+}
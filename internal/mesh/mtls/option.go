@@ -3,10 +3,18 @@ package mtls
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/caclient"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/fileca"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/stepca"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/vault"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/sds"
 )
 
 type Option struct {
@@ -28,9 +36,27 @@ type Option struct {
 	RotatePeriod time.Duration
 
 	// FailedRetryDelay It defines the retry interval when the rotation action failed.
+	// The interval is doubled on each failure up to MaxFailedRetryDelay.
 	// Default is 1 second.
 	FailedRetryDelay time.Duration
 
+	// MaxFailedRetryDelay is the max retry interval when the rotation action failed.
+	// Default is 5 minute.
+	MaxFailedRetryDelay time.Duration
+
+	// RotateFraction renews the certificate when the fraction of its lifetime has elapsed,
+	// RotatePeriod is only used when the lifetime is unknown.
+	// Default is 0.5.
+	RotateFraction float64
+
+	// RotateJitter is the random fraction of the lifetime added to or subtracted from the renewal time.
+	// Default is 0.1.
+	RotateJitter float64
+
+	// ExpiryAlertThreshold alerts when the certificate expires within the threshold and the renewal is failing.
+	// Default is 10 minute.
+	ExpiryAlertThreshold time.Duration
+
 	// Namespace is the current namespace.
 	Namespace string
 
@@ -38,17 +64,114 @@ type Option struct {
 	// See also: https://kubernetes.io/docs/tasks/configure-pod-container/configure-service-account/
 	ServiceAccount string
 
-	// CAServer is the address of the CA server.
+	// TrustDomain is the SPIFFE trust domain of the workload.
+	// Default is cluster.local.
+	TrustDomain string
+
+	// URITemplate is the template of the URI SAN, {trust_domain}, {namespace} and {service_account} are replaced.
+	// The namespace and the service account are not required if the template contains neither of them.
+	// Default is spiffe://{trust_domain}/ns/{namespace}/sa/{service_account}.
+	URITemplate string
+
+	// DNSNames are the extra DNS SANs of the workload certificate.
+	DNSNames []string
+
+	// IPAddresses are the extra IP SANs of the workload certificate.
+	IPAddresses []string
+
+	// ipAddresses the parsed IPAddresses
+	ipAddresses []net.IP
+
+	// CAProvider is the CA backend signing the certificates, one of polaris, file, stepca, vault.
+	// Default is polaris.
+	CAProvider string
+
+	// CAServer is the address of the CA server, multiple addresses are separated by comma.
 	CAServer string
 
+	// CAServers are the addresses of the polaris CA, the next one is tried when the current one is unavailable.
+	// Default is parsed from CAServer.
+	CAServers []string
+
+	// CARootFile is the root certificate verifying the polaris CA server besides the system roots.
+	// Default is /etc/polaris-sidecar/certs/rootca.pem.
+	CARootFile string
+
+	// CAClientCertFile and CAClientKeyFile are the client certificate authenticating to the polaris CA
+	// by mutual TLS, which are reloaded on each handshake. Empty means disabled.
+	CAClientCertFile string
+	CAClientKeyFile  string
+
+	// CATimeout is the max duration of a request to each polaris CA server.
+	// Default is 10 second.
+	CATimeout time.Duration
+
+	// TokenPath is the service account token authenticating to the polaris CA, the step-ca without token file and
+	// the vault kubernetes auth, which is reloaded when it changes.
+	// Default is /var/run/secrets/kubernetes.io/serviceaccount/token.
+	TokenPath string
+
+	// TokenAudience is the expected audience of the projected token, empty means no check.
+	TokenAudience string
+
+	// FileCA is the local CA used by the file provider.
+	FileCA *fileca.Config
+
+	// StepCA is the step-ca style CA used by the stepca provider.
+	StepCA *stepca.Config
+
+	// Vault is the Vault PKI secrets engine used by the vault provider.
+	Vault *vault.Config
+
+	// KeyAlgorithm is the algorithm of the private key, one of RSA, ECDSA_P256, ECDSA_P384.
+	// ED25519 is rejected since envoy does not load it from SDS. Default is RSA.
+	KeyAlgorithm string
+
 	// RSAKeyBits is the bit size of the RSA key.
 	RSAKeyBits int
 
+	// BundleCacheDir is the directory to persist the issued key, chain and root, which are served on restart
+	// if still valid. Empty means disabled.
+	BundleCacheDir string
+
+	// OutputDir is the directory to write the key, chain and root for the applications other than envoy,
+	// each file is replaced atomically on rotation. Empty means disabled.
+	OutputDir string
+
+	// OutputFileMode is the permission of the files in OutputDir.
+	// Default is 0600.
+	OutputFileMode os.FileMode
+
+	// CertAPIAddress is the unix socket serving the X.509 SVIDs as HTTP/JSON to the applications other than envoy,
+	// it is not the SPIFFE Workload API. Empty means disabled.
+	CertAPIAddress string
+
+	// CertAPIMode is the permission of the CertAPIAddress socket, the clients need the write permission.
+	// Default is 0600.
+	CertAPIMode os.FileMode
+
+	// Identities are the extra workload identities served as the SDS secrets of their names,
+	// which are generated when envoy requests them.
+	Identities []*Identity
+
+	// TrustBundles are the root certificate files of the federated trust domains by SDS secret name.
+	TrustBundles map[string]string
+
+	// ValidationContexts are served as the SDS secrets of their names.
+	ValidationContexts []*sds.ValidationContext
+
 	// TTL of the certificate
 	// Default is 1 hour.
 	TTL time.Duration
 }
 
+// Identity the workload identity served as the SDS secret of the name
+type Identity struct {
+	Name           string `yaml:"name"`
+	Namespace      string `yaml:"namespace"`
+	ServiceAccount string `yaml:"service_account"`
+}
+
 func EnvDefaultDuration(name string, val time.Duration, def time.Duration) time.Duration {
 	if val != 0 {
 		return val
@@ -59,6 +182,40 @@ func EnvDefaultDuration(name string, val time.Duration, def time.Duration) time.
 	return def
 }
 
+func EnvDefaultString(name string, val string, def string) string {
+	if val != "" {
+		return val
+	}
+	if d := os.Getenv(name); d != "" {
+		return d
+	}
+	return def
+}
+
+// EnvDefaultStrings returns the comma separated values of the env if val is empty
+func EnvDefaultStrings(name string, val []string) []string {
+	if len(val) > 0 {
+		return val
+	}
+	var values []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+func EnvDefaultFloat(name string, val float64, def float64) float64 {
+	if val != 0 {
+		return val
+	}
+	if d, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+		return d
+	}
+	return def
+}
+
 func EnvDefaultInt(name string, val int, def int) int {
 	if val != 0 {
 		return val
@@ -95,11 +252,42 @@ func (opt *Option) init() error {
 		opt.FailedRetryDelay,
 		time.Second)
 
-	if opt.Namespace == "" || opt.ServiceAccount == "" {
-		if envNS := os.Getenv("KUBERNETES_NAMESPACE"); envNS != "" {
+	opt.MaxFailedRetryDelay = EnvDefaultDuration("POLARIS_SIDECAR_MTLS_ROTATE_MAX_FAILED_RETRY_DELAY",
+		opt.MaxFailedRetryDelay,
+		5*time.Minute)
+
+	opt.RotateFraction = EnvDefaultFloat("POLARIS_SIDECAR_MTLS_ROTATE_FRACTION",
+		opt.RotateFraction,
+		0.5)
+
+	opt.RotateJitter = EnvDefaultFloat("POLARIS_SIDECAR_MTLS_ROTATE_JITTER",
+		opt.RotateJitter,
+		0.1)
+
+	opt.ExpiryAlertThreshold = EnvDefaultDuration("POLARIS_SIDECAR_MTLS_EXPIRY_ALERT_THRESHOLD",
+		opt.ExpiryAlertThreshold,
+		10*time.Minute)
+
+	opt.TrustDomain = EnvDefaultString("POLARIS_SIDECAR_MTLS_TRUST_DOMAIN", opt.TrustDomain,
+		certificate.DefaultTrustDomain)
+	opt.URITemplate = EnvDefaultString("POLARIS_SIDECAR_MTLS_URI_TEMPLATE", opt.URITemplate,
+		certificate.DefaultURITemplate)
+	opt.DNSNames = EnvDefaultStrings("POLARIS_SIDECAR_MTLS_DNS_SANS", opt.DNSNames)
+	opt.IPAddresses = EnvDefaultStrings("POLARIS_SIDECAR_MTLS_IP_SANS", opt.IPAddresses)
+	ips, err := certificate.ParseIPAddresses(opt.IPAddresses)
+	if err != nil {
+		return err
+	}
+	opt.ipAddresses = ips
+
+	// 虚拟机等没有 service account 的场景可以通过配置或环境变量指定身份
+	opt.Namespace = EnvDefaultString("POLARIS_SIDECAR_MTLS_NAMESPACE", opt.Namespace, "")
+	opt.ServiceAccount = EnvDefaultString("POLARIS_SIDECAR_MTLS_SERVICE_ACCOUNT", opt.ServiceAccount, "")
+	if (opt.Namespace == "" || opt.ServiceAccount == "") && opt.identity().RequiresServiceAccount() {
+		if envNS := os.Getenv("KUBERNETES_NAMESPACE"); envNS != "" && opt.Namespace == "" {
 			opt.Namespace = envNS
 		}
-		if envSA := os.Getenv("KUBERNETES_SERVICE_ACCOUNT"); envSA != "" {
+		if envSA := os.Getenv("KUBERNETES_SERVICE_ACCOUNT"); envSA != "" && opt.ServiceAccount == "" {
 			opt.ServiceAccount = envSA
 		}
 		if opt.Namespace == "" || opt.ServiceAccount == "" {
@@ -111,26 +299,98 @@ func (opt *Option) init() error {
 			opt.ServiceAccount = sa.AccountName
 		}
 	}
-	if opt.CAServer == "" {
-		ca := os.Getenv("POLARIS_SIDECAR_MTLS_CA_SERVER")
-		if ca == "" {
+	opt.CAProvider = strings.ToLower(EnvDefaultString("POLARIS_SIDECAR_MTLS_CA_PROVIDER", opt.CAProvider,
+		CAProviderPolaris))
+	switch opt.CAProvider {
+	case CAProviderPolaris:
+		if len(opt.CAServers) == 0 {
+			if opt.CAServer == "" {
+				opt.CAServer = os.Getenv("POLARIS_SIDECAR_MTLS_CA_SERVER")
+			}
+			opt.CAServers = strings.Split(opt.CAServer, ",")
+		}
+		servers := make([]string, 0, len(opt.CAServers))
+		for _, server := range opt.CAServers {
+			server = strings.TrimSpace(server)
+			if server == "" {
+				continue
+			}
+			lca := strings.ToLower(server)
+			if !strings.HasPrefix(lca, "http://") && !strings.HasPrefix(lca, "https://") {
+				// add scheme to endpoint
+				server = "http://" + server
+			}
+			servers = append(servers, server)
+		}
+		if len(servers) == 0 {
 			return errors.New("no ca server endpoint provided")
 		}
-		opt.CAServer = ca
+		opt.CAServers = servers
+		opt.CAServer = servers[0]
+		opt.CARootFile = EnvDefaultString("POLARIS_SIDECAR_MTLS_CA_ROOT_FILE", opt.CARootFile, DefaultCARootFile)
+		opt.CAClientCertFile = EnvDefaultString("POLARIS_SIDECAR_MTLS_CA_CLIENT_CERT_FILE", opt.CAClientCertFile, "")
+		opt.CAClientKeyFile = EnvDefaultString("POLARIS_SIDECAR_MTLS_CA_CLIENT_KEY_FILE", opt.CAClientKeyFile, "")
+		opt.CATimeout = EnvDefaultDuration("POLARIS_SIDECAR_MTLS_CA_TIMEOUT", opt.CATimeout, caclient.DefaultTimeout)
+	case CAProviderFile, CAProviderStepCA, CAProviderVault:
+	default:
+		return fmt.Errorf("unsupported ca provider %s, should be one of %s, %s, %s, %s", opt.CAProvider,
+			CAProviderPolaris, CAProviderFile, CAProviderStepCA, CAProviderVault)
 	}
+	// token 同时用于 step-ca 未配置令牌文件时的签发和 vault 的 kubernetes 认证
+	opt.TokenPath = EnvDefaultString("POLARIS_SIDECAR_MTLS_TOKEN_PATH", opt.TokenPath, caclient.SATLocation)
+	opt.TokenAudience = EnvDefaultString("POLARIS_SIDECAR_MTLS_TOKEN_AUDIENCE", opt.TokenAudience, "")
 
-	lca := strings.ToLower(opt.CAServer)
+	if err = opt.identity().Validate(); err != nil {
+		return err
+	}
 
-	if !strings.HasPrefix(lca, "http://") && !strings.HasPrefix(lca, "https://") {
-		// add scheme to endpoint
-		opt.CAServer = "http://" + opt.CAServer
+	keyAlgorithm, err := certificate.NormalizeKeyAlgorithm(EnvDefaultString("POLARIS_SIDECAR_MTLS_KEY_ALGORITHM",
+		opt.KeyAlgorithm, certificate.KeyAlgorithmRSA))
+	if err != nil {
+		return err
+	}
+	if keyAlgorithm == certificate.KeyAlgorithmEd25519 {
+		// envoy 的 TLS 证书只支持 RSA 和 ECDSA，SDS 下发 ED25519 证书会被拒绝
+		return fmt.Errorf("key algorithm %s is not supported by envoy, should be one of %s, %s, %s", keyAlgorithm,
+			certificate.KeyAlgorithmRSA, certificate.KeyAlgorithmECDSAP256, certificate.KeyAlgorithmECDSAP384)
 	}
+	opt.KeyAlgorithm = keyAlgorithm
 
 	opt.RSAKeyBits = EnvDefaultInt("POLARIS_SIDECAR_MTLS_KEY_BITS",
 		opt.RSAKeyBits, 2048)
 
+	opt.BundleCacheDir = EnvDefaultString("POLARIS_SIDECAR_MTLS_BUNDLE_CACHE_DIR", opt.BundleCacheDir, "")
+
+	opt.OutputDir = EnvDefaultString("POLARIS_SIDECAR_MTLS_OUTPUT_DIR", opt.OutputDir, "")
+	if opt.OutputFileMode == 0 {
+		opt.OutputFileMode = 0600
+	}
+	opt.CertAPIAddress = EnvDefaultString("POLARIS_SIDECAR_MTLS_CERT_API_ADDRESS",
+		opt.CertAPIAddress, "")
+	if opt.CertAPIMode == 0 {
+		opt.CertAPIMode = 0600
+	}
+
 	opt.TTL = EnvDefaultDuration("POLARIS_SIDECAR_MTLS_CERT_TTL",
 		opt.TTL, time.Hour)
 
 	return nil
 }
+
+// identity returns the identity of the workload, which has the extra SANs
+func (opt *Option) identity() *certificate.Identity {
+	identity := opt.identityOf(opt.Namespace, opt.ServiceAccount)
+	identity.DNSNames = opt.DNSNames
+	identity.IPAddresses = opt.ipAddresses
+	return identity
+}
+
+// identityOf returns the identity of the service account in the trust domain
+func (opt *Option) identityOf(namespace string, serviceAccount string) *certificate.Identity {
+	return &certificate.Identity{
+		TrustDomain:    opt.TrustDomain,
+		URITemplate:    opt.URITemplate,
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+	}
+}
@@ -0,0 +1,70 @@
+package mtls
+
+import (
+	"context"
+	"os"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certapi"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	"github.com/polarismesh/polaris-sidecar/pkg/graceful"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+// publish serve the workload certificate to envoy by SDS, and to the other applications by the output dir
+// and the cert api
+func (a *Agent) publish(ctx context.Context, bundle *certificate.Bundle) {
+	a.bundleMutex.Lock()
+	a.bundle = bundle
+	a.bundleMutex.Unlock()
+	a.sds.UpdateSecrets(ctx, *bundle)
+	if len(a.opt.OutputDir) > 0 {
+		if err := certificate.WriteBundle(a.opt.OutputDir, bundle, a.opt.OutputFileMode); err != nil {
+			log.Errorf("[envoy-mtls] write certificate bundle to %s failed: %v", a.opt.OutputDir, err)
+		}
+	}
+	if a.certAPI != nil {
+		a.certAPI.Update(a.x509SVIDResponse(bundle))
+	}
+}
+
+func (a *Agent) x509SVIDResponse(bundle *certificate.Bundle) *certapi.X509SVIDResponse {
+	var spiffeID string
+	if uri, err := a.opt.identity().URI(); err == nil {
+		spiffeID = uri.String()
+	}
+	resp := &certapi.X509SVIDResponse{
+		SVIDs: []*certapi.X509SVID{{
+			SpiffeID:    spiffeID,
+			X509SVID:    string(bundle.CertChain),
+			X509SVIDKey: string(bundle.PrivKey),
+			Bundle:      string(bundle.ROOTCA),
+		}},
+	}
+	if len(a.trustBundles) > 0 {
+		resp.FederatedBundles = make(map[string]string, len(a.trustBundles))
+		for name, roots := range a.trustBundles {
+			resp.FederatedBundles[name] = string(roots)
+		}
+	}
+	return resp
+}
+
+// serveCertAPI listen on the unix socket of the cert api, and serve it in background
+func (a *Agent) serveCertAPI(errChan chan error) error {
+	ln, err := graceful.Listen("unix", a.opt.CertAPIAddress)
+	if err != nil {
+		return err
+	}
+	// 证书私钥可通过 socket 获取，仅允许配置的用户访问
+	if err = os.Chmod(a.opt.CertAPIAddress, a.opt.CertAPIMode); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	go func() {
+		if err := a.certAPI.Serve(ln); err != nil {
+			errChan <- err
+		}
+	}()
+	log.Infof("[envoy-mtls] serve cert api on %s", a.opt.CertAPIAddress)
+	return nil
+}
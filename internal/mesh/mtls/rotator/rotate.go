@@ -2,64 +2,243 @@ package rotator
 
 import (
 	"context"
+	"crypto/x509"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
+var (
+	expirationGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "polaris_sidecar_mtls_cert_expiration_timestamp_seconds",
+		Help: "Expiration time of the current workload certificate in unix seconds",
+	}, []string{"secret"})
+	expiringGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "polaris_sidecar_mtls_cert_expiring",
+		Help: "Whether the current workload certificate expires within the alert threshold without renewal",
+	}, []string{"secret"})
+	failedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "polaris_sidecar_mtls_rotation_failed_total",
+		Help: "Count of the failed workload certificate rotations",
+	}, []string{"secret"})
+)
+
+func init() {
+	prometheus.MustRegister(expirationGauge, expiringGauge, failedCounter)
+}
+
+// Config the rotation schedule
+type Config struct {
+	// Name the secret name of the rotated certificate, which labels the metrics
+	Name string
+	// Period the rotation period when the lifetime of the certificate is unknown
+	Period time.Duration
+	// RetryDelay the initial delay to retry after failure, it is doubled on each failure
+	RetryDelay time.Duration
+	// MaxRetryDelay the max delay to retry after failure
+	MaxRetryDelay time.Duration
+	// RenewFraction renew the certificate when the fraction of its lifetime has elapsed
+	RenewFraction float64
+	// Jitter the random fraction of the lifetime added to or subtracted from the renewal time,
+	// so that the workloads do not renew at the same time. It is clamped to keep the renewal within the lifetime
+	Jitter float64
+	// AlertThreshold alert when the certificate expires within the threshold and the renewal is failing
+	AlertThreshold time.Duration
+}
+
+// RotateFunc issue and apply a new certificate, it returns the leaf certificate to schedule the next rotation,
+// nil leaf means the lifetime is unknown and the fixed period is used
+type RotateFunc func(ctx context.Context) (*x509.Certificate, error)
+
+// Status the state of the rotation
+type Status struct {
+	// LastRotation the time of the last successful rotation
+	LastRotation time.Time `json:"last_rotation,omitempty"`
+	// NextRotation the time of the next scheduled rotation
+	NextRotation time.Time `json:"next_rotation,omitempty"`
+	// LastError the error of the last failed rotation, it is cleared on success
+	LastError string `json:"last_error,omitempty"`
+	// LastErrorTime the time of the last failed rotation
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+	// ConsecutiveFailures the count of the failed rotations since the last success
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// Expiring whether the certificate expires within the alert threshold without renewal
+	Expiring bool `json:"expiring"`
+}
+
 type Rotator struct {
-	once       sync.Once
-	period     time.Duration
-	retryDelay time.Duration
+	once   sync.Once
+	config Config
+	now    func() time.Time
+	random func() float64
+
+	mutex  sync.Mutex
+	status Status
 }
 
-func New(period time.Duration, retryDelay time.Duration) *Rotator {
+func New(config Config) *Rotator {
 	return &Rotator{
-		period:     period,
-		retryDelay: retryDelay,
+		config: config,
+		now:    time.Now,
+		random: rand.Float64,
 	}
 }
 
 func (r *Rotator) init() {
-	if r.period == 0 {
-		r.period = time.Minute * 30
+	if r.config.Period == 0 {
+		r.config.Period = time.Minute * 30
 	}
 
-	if r.retryDelay == 0 {
-		r.retryDelay = time.Second
+	if r.config.RetryDelay == 0 {
+		r.config.RetryDelay = time.Second
+	}
+
+	if r.config.MaxRetryDelay < r.config.RetryDelay {
+		r.config.MaxRetryDelay = r.config.RetryDelay
+	}
+
+	if r.config.RenewFraction <= 0 || r.config.RenewFraction >= 1 {
+		r.config.RenewFraction = 0.5
+	}
+
+	if r.config.Jitter < 0 {
+		r.config.Jitter = 0
+	}
+	// 抖动后的续期时间需要落在证书有效期内
+	if limit := math.Min(r.config.RenewFraction, 1-r.config.RenewFraction); r.config.Jitter > limit {
+		r.config.Jitter = limit
 	}
 }
 
-func (r *Rotator) execute(ctx context.Context, f func(ctx context.Context) error) {
+// execute run f until success, the delay between retries grows exponentially. It returns false if ctx is done.
+func (r *Rotator) execute(ctx context.Context, f RotateFunc, current *x509.Certificate) (*x509.Certificate, bool) {
+	retryDelay := r.config.RetryDelay
 	for {
 		log.Infof("will execute by rotator")
-		err := f(ctx)
+		leaf, err := f(ctx)
 		if err == nil {
-			break
+			if leaf != nil {
+				expirationGauge.WithLabelValues(r.config.Name).Set(float64(leaf.NotAfter.Unix()))
+			}
+			expiringGauge.WithLabelValues(r.config.Name).Set(0)
+			r.updateStatus(func(status *Status) {
+				status.LastRotation = r.now()
+				status.LastError = ""
+				status.ConsecutiveFailures = 0
+				status.Expiring = false
+			})
+			return leaf, true
 		}
 		if ctx.Err() != nil {
-			return
+			return nil, false
+		}
+		failedCounter.WithLabelValues(r.config.Name).Inc()
+		log.Errorf("action executed failed, retry after %v: %s", retryDelay, err.Error())
+		expiring := r.checkExpiry(current)
+		r.updateStatus(func(status *Status) {
+			status.LastError = err.Error()
+			status.LastErrorTime = r.now()
+			status.ConsecutiveFailures++
+			status.NextRotation = r.now().Add(retryDelay)
+			status.Expiring = expiring
+		})
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(retryDelay):
 		}
-		log.Errorf("action executed failed: %s", err.Error())
-		time.Sleep(r.retryDelay)
+		retryDelay *= 2
+		if retryDelay > r.config.MaxRetryDelay {
+			retryDelay = r.config.MaxRetryDelay
+		}
+	}
+}
+
+// checkExpiry alert if the current certificate is about to expire, and returns whether it is expiring
+func (r *Rotator) checkExpiry(current *x509.Certificate) bool {
+	if current == nil || r.config.AlertThreshold <= 0 {
+		return false
+	}
+	remaining := current.NotAfter.Sub(r.now())
+	if remaining > r.config.AlertThreshold {
+		return false
+	}
+	expiringGauge.WithLabelValues(r.config.Name).Set(1)
+	if remaining <= 0 {
+		log.Errorf("[ALERT] certificate %s has expired at %v", current.SerialNumber, current.NotAfter)
+		return true
 	}
+	log.Errorf("[ALERT] certificate %s expires in %v, but the renewal is failing", current.SerialNumber,
+		remaining.Round(time.Second))
+	return true
 }
 
-func (r *Rotator) Run(ctx context.Context, f func(ctx context.Context) error) error {
+func (r *Rotator) updateStatus(update func(status *Status)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	update(&r.status)
+}
+
+// Status returns the state of the rotation
+func (r *Rotator) Status() Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.status
+}
+
+// nextRotation returns the delay to the next rotation, which is the renew fraction of the leaf lifetime with jitter
+func (r *Rotator) nextRotation(leaf *x509.Certificate) time.Duration {
+	if leaf == nil {
+		return r.config.Period
+	}
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	if lifetime <= 0 {
+		return r.config.RetryDelay
+	}
+	fraction := r.config.RenewFraction + r.config.Jitter*(2*r.random()-1)
+	renewAt := leaf.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+	delay := renewAt.Sub(r.now())
+	if delay < r.config.RetryDelay {
+		return r.config.RetryDelay
+	}
+	return delay
+}
+
+// Run rotate until ctx is done. current is the certificate in use such as the one loaded from disk,
+// the first rotation is scheduled by its lifetime if present, otherwise f is executed immediately.
+func (r *Rotator) Run(ctx context.Context, current *x509.Certificate, f RotateFunc) error {
 	r.once.Do(r.init)
-	r.execute(ctx, f)
+	var delay time.Duration
+	if current != nil {
+		expirationGauge.WithLabelValues(r.config.Name).Set(float64(current.NotAfter.Unix()))
+		delay = r.nextRotation(current)
+		log.Infof("next rotation after %v", delay.Round(time.Second))
+	}
+	r.scheduled(delay)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(r.period):
+		case <-time.After(delay):
 		}
-
-		r.execute(ctx, f)
-
-		if ctx.Err() != nil {
+		leaf, ok := r.execute(ctx, f, current)
+		if !ok {
 			return ctx.Err()
 		}
+		current = leaf
+		delay = r.nextRotation(leaf)
+		log.Infof("next rotation after %v", delay.Round(time.Second))
+		r.scheduled(delay)
 	}
 }
+
+func (r *Rotator) scheduled(delay time.Duration) {
+	r.updateStatus(func(status *Status) {
+		status.NextRotation = r.now().Add(delay)
+	})
+}
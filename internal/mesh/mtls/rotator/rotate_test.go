@@ -0,0 +1,123 @@
+package rotator
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotator_nextRotation(t *testing.T) {
+	now := time.Now()
+	r := New(Config{Period: time.Hour, RetryDelay: time.Second, RenewFraction: 0.5, Jitter: 0.1})
+	r.init()
+	r.now = func() time.Time { return now }
+	leaf := &x509.Certificate{NotBefore: now, NotAfter: now.Add(100 * time.Minute)}
+
+	r.random = func() float64 { return 0.5 }
+	assert.Equal(t, 50*time.Minute, r.nextRotation(leaf))
+	r.random = func() float64 { return 0 }
+	assert.Equal(t, 40*time.Minute, r.nextRotation(leaf))
+	r.random = func() float64 { return 1 }
+	assert.Equal(t, 60*time.Minute, r.nextRotation(leaf))
+
+	// 生命周期未知时使用固定周期
+	assert.Equal(t, time.Hour, r.nextRotation(nil))
+	// 已经过了续期时间
+	r.now = func() time.Time { return now.Add(90 * time.Minute) }
+	assert.Equal(t, time.Second, r.nextRotation(leaf))
+}
+
+func TestRotator_nextRotationClampJitter(t *testing.T) {
+	now := time.Now()
+	r := New(Config{RetryDelay: time.Second, RenewFraction: 0.8, Jitter: 0.3})
+	r.init()
+	r.now = func() time.Time { return now }
+	leaf := &x509.Certificate{NotBefore: now, NotAfter: now.Add(100 * time.Minute)}
+
+	assert.InDelta(t, 0.2, r.config.Jitter, 1e-9)
+	// rand.Float64 返回 [0, 1)，续期时间总是早于过期时间
+	r.random = func() float64 { return 0.99 }
+	assert.Less(t, r.nextRotation(leaf), 100*time.Minute)
+	r.random = func() float64 { return 0 }
+	assert.Equal(t, 60*time.Minute, r.nextRotation(leaf).Round(time.Second))
+}
+
+func TestRotator_execute(t *testing.T) {
+	r := New(Config{RetryDelay: 10 * time.Millisecond, MaxRetryDelay: 40 * time.Millisecond})
+	r.init()
+	var calls []time.Time
+	leaf := &x509.Certificate{}
+	start := time.Now()
+	result, ok := r.execute(context.Background(), func(ctx context.Context) (*x509.Certificate, error) {
+		calls = append(calls, time.Now())
+		if len(calls) < 5 {
+			return nil, errors.New("ca unavailable")
+		}
+		return leaf, nil
+	}, nil)
+	assert.True(t, ok)
+	assert.Equal(t, leaf, result)
+	assert.Len(t, calls, 5)
+	// 10 + 20 + 40 + 40 毫秒
+	assert.GreaterOrEqual(t, time.Since(start), 110*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = r.execute(ctx, func(ctx context.Context) (*x509.Certificate, error) {
+		return nil, ctx.Err()
+	}, nil)
+	assert.False(t, ok)
+}
+
+func TestRotator_Status(t *testing.T) {
+	now := time.Now()
+	r := New(Config{RetryDelay: time.Millisecond, AlertThreshold: time.Hour})
+	r.init()
+	r.now = func() time.Time { return now }
+	current := &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Minute)}
+	failures := 0
+	_, ok := r.execute(context.Background(), func(ctx context.Context) (*x509.Certificate, error) {
+		if failures < 2 {
+			failures++
+			assert.Equal(t, failures-1, r.Status().ConsecutiveFailures)
+			return nil, errors.New("ca unavailable")
+		}
+		// 失败时记录最近一次 CA 错误，且证书即将过期
+		status := r.Status()
+		assert.Equal(t, "ca unavailable", status.LastError)
+		assert.Equal(t, 2, status.ConsecutiveFailures)
+		assert.True(t, status.Expiring)
+		return nil, nil
+	}, current)
+	assert.True(t, ok)
+	status := r.Status()
+	assert.Equal(t, now, status.LastRotation)
+	assert.Equal(t, now, status.LastErrorTime)
+	assert.Empty(t, status.LastError)
+	assert.Zero(t, status.ConsecutiveFailures)
+	assert.False(t, status.Expiring)
+}
+
+func TestRotator_metricsBySecret(t *testing.T) {
+	now := time.Now()
+	defaultRotator := New(Config{Name: "default"})
+	extraRotator := New(Config{Name: "spiffe://cluster.local/ns/default/sa/extra"})
+	defaultLeaf := &x509.Certificate{NotAfter: now.Add(time.Hour)}
+	extraLeaf := &x509.Certificate{NotAfter: now.Add(2 * time.Hour)}
+
+	ctx := context.Background()
+	_, ok := defaultRotator.execute(ctx, func(ctx context.Context) (*x509.Certificate, error) { return defaultLeaf, nil }, nil)
+	assert.True(t, ok)
+	_, ok = extraRotator.execute(ctx, func(ctx context.Context) (*x509.Certificate, error) { return extraLeaf, nil }, nil)
+	assert.True(t, ok)
+
+	// 额外身份的轮换不会覆盖默认证书的指标
+	assert.Equal(t, float64(defaultLeaf.NotAfter.Unix()), testutil.ToFloat64(expirationGauge.WithLabelValues("default")))
+	assert.Equal(t, float64(extraLeaf.NotAfter.Unix()),
+		testutil.ToFloat64(expirationGauge.WithLabelValues("spiffe://cluster.local/ns/default/sa/extra")))
+}
@@ -8,12 +8,9 @@ import (
 	cryptomb "github.com/envoyproxy/go-control-plane/contrib/envoy/extensions/private_key_providers/cryptomb/v3alpha"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoytls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
-	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/klauspost/cpuid"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
-
-	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
 )
 
 // cryptombSupported indicate that whether the cpu can use crypto_mb library.
@@ -24,17 +21,6 @@ import (
 var cryptombSupported = cpuid.CPU.AVX512F() && cpuid.CPU.AVX512DQ() && cpuid.CPU.AVX512BW() && cpuid.CPU.AVX512IFMA() &&
 	cpuid.CPU.AVX512VBMI2()
 
-// makeSecrets make all secrets which should be pushed to envoy.
-// For now, just ROOTCA & default.
-func (s *Server) makeSecrets(bundle certificate.Bundle) []types.Resource {
-	results := []types.Resource{}
-
-	rootCA := s.makeCASecret("ROOTCA", bundle.ROOTCA)
-	def := s.makeSecret("default", bundle.PrivKey, bundle.CertChain, s.cryptombPollDelay)
-	results = append(results, rootCA, def)
-	return results
-}
-
 // makeSecret make secret object with the specified name.
 // key and cryptombPollDelay are optional parameters.
 // If key and cryptombPollDelay are provided, and the `cryptombSupported` is true,
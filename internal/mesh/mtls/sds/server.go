@@ -2,11 +2,17 @@ package sds
 
 import (
 	"context"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoytls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	secretv3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	matcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
@@ -17,31 +23,209 @@ import (
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
+const (
+	// DefaultSecretName the secret of the workload identity
+	DefaultSecretName = "default"
+	// RootCASecretName the secret of the root certificate issued by the CA
+	RootCASecretName = "ROOTCA"
+
+	// generateTimeout max duration to generate the secret requested by envoy
+	generateTimeout = 10 * time.Second
+	// generateRetryDelay the delay to generate the failed secret again, doubled on each failure
+	generateRetryDelay = time.Second
+	// maxGenerateRetryDelay max delay to generate the failed secret again
+	maxGenerateRetryDelay = 5 * time.Minute
+)
+
+// ValidationContext the trust bundles and the SAN matchers to validate the peer certificates
+type ValidationContext struct {
+	Name string `yaml:"name"`
+	// TrustBundles names of the trusted roots, ROOTCA refers to the root certificate issued by the CA
+	TrustBundles []string `yaml:"trust_bundles"`
+	// MatchSANs the peer URI SAN should match one of them, the value ending with "*" matches by prefix,
+	// empty means no SAN check
+	MatchSANs []string `yaml:"match_sans"`
+}
+
+// Generator generate the secret of the name which has not been created yet, it should update the identity
+// by UpdateIdentity before returning
+type Generator func(ctx context.Context, name string) error
+
 type Server struct {
 	srv               serverv3.Server
 	snap              cache.SnapshotCache
 	cryptombPollDelay time.Duration
+
+	mutex sync.Mutex
+	// identities the workload certificates by secret name
+	identities map[string]certificate.Bundle
+	// trustBundles the trusted roots by secret name
+	trustBundles map[string][]byte
+	validations  map[string]*ValidationContext
+	generator    Generator
+	// generating the secrets being generated
+	generating map[string]struct{}
+	// failures the secrets failed to generate, which are not generated again until the retry time
+	failures map[string]*generateFailure
+	now      func() time.Time
+}
+
+// generateFailure the consecutive failures to generate a secret
+type generateFailure struct {
+	count   int
+	retryAt time.Time
 }
 
 func New(cryptombPollDelay time.Duration) (s *Server) {
-	s = &Server{}
+	s = &Server{
+		identities:   map[string]certificate.Bundle{},
+		trustBundles: map[string][]byte{},
+		validations:  map[string]*ValidationContext{},
+		generating:   map[string]struct{}{},
+		failures:     map[string]*generateFailure{},
+		now:          time.Now,
+	}
 	s.cryptombPollDelay = cryptombPollDelay
 
 	snap := cache.NewSnapshotCache(false, defaultHash, nil)
-	srv := serverv3.NewServer(context.TODO(), snap, nil)
+	srv := serverv3.NewServer(context.TODO(), snap, serverv3.CallbackFuncs{
+		StreamRequestFunc: func(_ int64, req *discovery.DiscoveryRequest) error {
+			s.ensureSecrets(req.GetResourceNames())
+			return nil
+		},
+		StreamDeltaRequestFunc: func(_ int64, req *discovery.DeltaDiscoveryRequest) error {
+			s.ensureSecrets(req.GetResourceNamesSubscribe())
+			return nil
+		},
+	})
 	s.srv = srv
 	s.snap = snap
 
 	return s
 }
 
+// SetGenerator set the generator of the secrets requested by envoy but not created yet
+func (s *Server) SetGenerator(generator Generator) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.generator = generator
+}
+
+// SetTrustBundles set the trusted roots of the federated trust domains, the names should not conflict with ROOTCA
+func (s *Server) SetTrustBundles(ctx context.Context, bundles map[string][]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name, roots := range bundles {
+		s.trustBundles[name] = roots
+	}
+	s.publish(ctx)
+}
+
+// SetValidationContexts set the validation contexts which are served as the secrets of their names
+func (s *Server) SetValidationContexts(ctx context.Context, validations []*ValidationContext) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, validation := range validations {
+		s.validations[validation.Name] = validation
+	}
+	s.publish(ctx)
+}
+
+// UpdateSecrets update the default workload identity and the root certificate
 func (s *Server) UpdateSecrets(ctx context.Context, bundle certificate.Bundle) {
+	s.UpdateIdentity(ctx, DefaultSecretName, bundle)
+}
+
+// UpdateIdentity update the workload certificate served as the secret of the name,
+// the root certificate is updated by the default identity
+func (s *Server) UpdateIdentity(ctx context.Context, name string, bundle certificate.Bundle) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.identities[name] = bundle
+	if name == DefaultSecretName {
+		s.trustBundles[RootCASecretName] = bundle.ROOTCA
+	}
+	s.publish(ctx)
+}
+
+// HasSecret returns whether the secret of the name has been created
+func (s *Server) HasSecret(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hasSecret(name)
+}
+
+func (s *Server) hasSecret(name string) bool {
+	if _, ok := s.identities[name]; ok {
+		return true
+	}
+	if _, ok := s.trustBundles[name]; ok {
+		return true
+	}
+	_, ok := s.validations[name]
+	return ok
+}
+
+// ensureSecrets generate the requested secrets which have not been created yet in background, the watch of
+// the request is answered once they are updated. The secrets failed to generate are skipped until the retry time.
+func (s *Server) ensureSecrets(names []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.generator == nil {
+		return
+	}
+	now := s.now()
+	for _, name := range names {
+		if s.hasSecret(name) {
+			continue
+		}
+		if _, ok := s.generating[name]; ok {
+			continue
+		}
+		if failure, ok := s.failures[name]; ok && now.Before(failure.retryAt) {
+			log.Debugf("generate secret %s on demand is delayed until %v", name, failure.retryAt)
+			continue
+		}
+		s.generating[name] = struct{}{}
+		go s.generate(s.generator, name)
+	}
+}
+
+// generate the secret by the generator, and record the failure to delay the next attempt
+func (s *Server) generate(generator Generator, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
+	err := generator(ctx, name)
+	cancel()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.generating, name)
+	if err == nil {
+		delete(s.failures, name)
+		return
+	}
+	failure, ok := s.failures[name]
+	if !ok {
+		failure = &generateFailure{}
+		s.failures[name] = failure
+	}
+	failure.count++
+	delay := maxGenerateRetryDelay
+	if failure.count <= 20 {
+		delay = min(generateRetryDelay<<(failure.count-1), maxGenerateRetryDelay)
+	}
+	failure.retryAt = s.now().Add(delay)
+	log.Errorf("generate secret %s on demand failed %d times, retry after %v: %s", name, failure.count, delay, err)
+}
+
+// publish set the snapshot with all the secrets, envoy only receives the requested ones
+func (s *Server) publish(ctx context.Context) {
 	version := strconv.Itoa(int(time.Now().UnixNano()))
 
 	log.Infof("update secrets: %s", version)
 
 	resources := make(map[resource.Type][]types.Resource)
-	resources[resource.SecretType] = s.makeSecrets(bundle)
+	resources[resource.SecretType] = s.makeSecrets()
 	snapshot, _ := cache.NewSnapshot(version, resources)
 	err := s.snap.SetSnapshot(ctx, string(defaultHash), snapshot)
 	if err != nil {
@@ -49,10 +233,92 @@ func (s *Server) UpdateSecrets(ctx context.Context, bundle certificate.Bundle) {
 	}
 }
 
+// makeSecrets make all secrets which should be pushed to envoy: the workload identities,
+// the trust bundles and the validation contexts.
+func (s *Server) makeSecrets() []types.Resource {
+	results := make([]types.Resource, 0, len(s.identities)+len(s.trustBundles)+len(s.validations))
+	for _, name := range sortedKeys(s.identities) {
+		bundle := s.identities[name]
+		results = append(results, s.makeSecret(name, bundle.PrivKey, bundle.CertChain,
+			s.pollDelayOf(bundle.KeyAlgorithm)))
+	}
+	for _, name := range sortedKeys(s.trustBundles) {
+		results = append(results, s.makeCASecret(name, s.trustBundles[name]))
+	}
+	for _, name := range sortedKeys(s.validations) {
+		if _, ok := s.trustBundles[name]; ok {
+			log.Warnf("validation context %s conflicts with the trust bundle, skip it", name)
+			continue
+		}
+		results = append(results, s.makeValidationSecret(s.validations[name]))
+	}
+	return results
+}
+
+// makeValidationSecret make the validation context trusting the roots of the bundles and matching the URI SANs
+func (s *Server) makeValidationSecret(validation *ValidationContext) *envoytls.Secret {
+	var roots []byte
+	for _, name := range validation.TrustBundles {
+		bundle, ok := s.trustBundles[name]
+		if !ok {
+			log.Warnf("trust bundle %s of validation context %s not found", name, validation.Name)
+			continue
+		}
+		roots = append(roots, bundle...)
+		if len(roots) > 0 && roots[len(roots)-1] != '\n' {
+			roots = append(roots, '\n')
+		}
+	}
+	ctx := &envoytls.CertificateValidationContext{
+		TrustedCa: &core.DataSource{
+			Specifier: &core.DataSource_InlineBytes{
+				InlineBytes: roots,
+			},
+		},
+	}
+	for _, san := range validation.MatchSANs {
+		matcher := &matcherv3.StringMatcher{MatchPattern: &matcherv3.StringMatcher_Exact{Exact: san}}
+		if strings.HasSuffix(san, "*") {
+			matcher.MatchPattern = &matcherv3.StringMatcher_Prefix{Prefix: strings.TrimSuffix(san, "*")}
+		}
+		ctx.MatchTypedSubjectAltNames = append(ctx.MatchTypedSubjectAltNames, &envoytls.SubjectAltNameMatcher{
+			SanType: envoytls.SubjectAltNameMatcher_URI,
+			Matcher: matcher,
+		})
+	}
+	return &envoytls.Secret{
+		Name: validation.Name,
+		Type: &envoytls.Secret_ValidationContext{
+			ValidationContext: ctx,
+		},
+	}
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pollDelayOf returns the cryptomb poll delay for the key, cryptomb only accelerates RSA and ECDSA P-256 keys,
+// 0 means the key is configured directly
+func (s *Server) pollDelayOf(keyAlgorithm string) time.Duration {
+	switch keyAlgorithm {
+	case certificate.KeyAlgorithmRSA, certificate.KeyAlgorithmECDSAP256:
+		return s.cryptombPollDelay
+	}
+	return 0
+}
+
 func (s *Server) Serve(srv *grpc.Server) {
 	secretv3.RegisterSecretDiscoveryServiceServer(srv, s.srv)
 }
 
+// defaultHash all nodes share the same snapshot, since the server listens on the local socket of the sidecar
+// and only serves the envoy of the same workload
 var defaultHash = ConstHash("default")
 
 // ConstHash uses a const string as the node hash.
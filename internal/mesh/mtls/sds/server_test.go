@@ -0,0 +1,111 @@
+package sds
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	envoytls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+)
+
+func secretNames(s *Server) []string {
+	var names []string
+	for _, res := range s.makeSecrets() {
+		names = append(names, res.(*envoytls.Secret).GetName())
+	}
+	return names
+}
+
+func TestServer_makeSecrets(t *testing.T) {
+	s := New(0)
+	ctx := context.Background()
+	s.UpdateSecrets(ctx, certificate.Bundle{ROOTCA: []byte("root"), CertChain: []byte("chain"), PrivKey: []byte("key")})
+	s.UpdateIdentity(ctx, "payment", certificate.Bundle{CertChain: []byte("chain2"), PrivKey: []byte("key2")})
+	s.SetTrustBundles(ctx, map[string][]byte{"partner": []byte("partner-root")})
+	s.SetValidationContexts(ctx, []*ValidationContext{
+		{
+			Name:         "partner-validation",
+			TrustBundles: []string{RootCASecretName, "partner"},
+			MatchSANs:    []string{"spiffe://partner.example.com/ns/*", "spiffe://cluster.local/ns/default/sa/web"},
+		},
+		{Name: "partner"},
+	})
+
+	// 与 trust bundle 同名的 validation context 被忽略
+	assert.Equal(t, []string{DefaultSecretName, "payment", RootCASecretName, "partner", "partner-validation"},
+		secretNames(s))
+	assert.True(t, s.HasSecret("partner-validation"))
+	assert.False(t, s.HasSecret("unknown"))
+
+	validation := s.makeValidationSecret(s.validations["partner-validation"]).GetValidationContext()
+	assert.Equal(t, "root\npartner-root\n", string(validation.GetTrustedCa().GetInlineBytes()))
+	matchers := validation.GetMatchTypedSubjectAltNames()
+	assert.Len(t, matchers, 2)
+	assert.Equal(t, envoytls.SubjectAltNameMatcher_URI, matchers[0].GetSanType())
+	assert.Equal(t, "spiffe://partner.example.com/ns/", matchers[0].GetMatcher().GetPrefix())
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/web", matchers[1].GetMatcher().GetExact())
+}
+
+// waitGenerated wait until no secret is being generated
+func waitGenerated(t *testing.T, s *Server) {
+	assert.Eventually(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return len(s.generating) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestServer_ensureSecrets(t *testing.T) {
+	s := New(0)
+	s.ensureSecrets([]string{"payment"})
+
+	var mutex sync.Mutex
+	var generated []string
+	fail := true
+	s.SetGenerator(func(ctx context.Context, name string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		generated = append(generated, name)
+		if fail {
+			return errors.New("ca unavailable")
+		}
+		s.UpdateIdentity(ctx, name, certificate.Bundle{CertChain: []byte("chain"), PrivKey: []byte("key")})
+		return nil
+	})
+	now := time.Now()
+	s.mutex.Lock()
+	s.now = func() time.Time {
+		return now
+	}
+	s.mutex.Unlock()
+	s.SetTrustBundles(context.Background(), map[string][]byte{"partner": []byte("partner-root")})
+	s.ensureSecrets([]string{"payment", "partner"})
+	waitGenerated(t, s)
+	assert.False(t, s.HasSecret("payment"))
+
+	// 失败后在退避时间内不再签发
+	s.ensureSecrets([]string{"payment"})
+	waitGenerated(t, s)
+	s.mutex.Lock()
+	now = now.Add(generateRetryDelay)
+	s.mutex.Unlock()
+	mutex.Lock()
+	fail = false
+	mutex.Unlock()
+	s.ensureSecrets([]string{"payment"})
+	waitGenerated(t, s)
+	assert.True(t, s.HasSecret("payment"))
+	s.ensureSecrets([]string{"payment"})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"payment", "payment"}, generated)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	assert.Empty(t, s.failures)
+}
@@ -0,0 +1,166 @@
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/polarismesh/polaris-sidecar/internal/debugger"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/rotator"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+// StatusPath the debug path of the certificate status
+const StatusPath = "/debug/mtls/certificate"
+
+// ChainCertificate the certificate in the issuer chain
+type ChainCertificate struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotAfter     time.Time `json:"not_after"`
+}
+
+// CertificateStatus the workload certificate served by the agent and the state of its rotation
+type CertificateStatus struct {
+	Subject      string    `json:"subject"`
+	SpiffeID     string    `json:"spiffe_id"`
+	URIs         []string  `json:"uris,omitempty"`
+	DNSNames     []string  `json:"dns_names,omitempty"`
+	IPAddresses  []string  `json:"ip_addresses,omitempty"`
+	SerialNumber string    `json:"serial_number"`
+	KeyAlgorithm string    `json:"key_algorithm"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	// ExpiresIn the remaining lifetime, which is the same as polaris_sidecar_mtls_cert_expiration_timestamp_seconds
+	ExpiresIn string `json:"expires_in"`
+	// Chain the issuers of the leaf certificate
+	Chain []*ChainCertificate `json:"chain,omitempty"`
+	// RootFingerprint the SHA-256 fingerprint of the root certificate
+	RootFingerprint string `json:"root_fingerprint"`
+	// Rotation the state of the rotation, which includes the last CA error
+	Rotation rotator.Status `json:"rotation"`
+	// Error why the certificate can not be parsed, or it has not been issued yet
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns the status of the workload certificate
+func (a *Agent) Status() *CertificateStatus {
+	a.bundleMutex.Lock()
+	bundle := a.bundle
+	a.bundleMutex.Unlock()
+	status := &CertificateStatus{Rotation: a.rotator.Status()}
+	if bundle == nil {
+		status.Error = "certificate has not been issued yet"
+		return status
+	}
+	if err := status.inspect(bundle, time.Now()); err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// inspect fill the status with the certificates of the bundle
+func (s *CertificateStatus) inspect(bundle *certificate.Bundle, now time.Time) error {
+	s.KeyAlgorithm = bundle.KeyAlgorithm
+	chain, err := parseCertificates(bundle.CertChain)
+	if err != nil {
+		return err
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("no certificate found in chain")
+	}
+	leaf := chain[0]
+	s.Subject = leaf.Subject.String()
+	for _, uri := range leaf.URIs {
+		s.URIs = append(s.URIs, uri.String())
+		if uri.Scheme == certificate.SchemeSPIFFE && s.SpiffeID == "" {
+			s.SpiffeID = uri.String()
+		}
+	}
+	s.DNSNames = leaf.DNSNames
+	for _, ip := range leaf.IPAddresses {
+		s.IPAddresses = append(s.IPAddresses, ip.String())
+	}
+	s.SerialNumber = formatSerial(leaf)
+	s.NotBefore = leaf.NotBefore
+	s.NotAfter = leaf.NotAfter
+	s.ExpiresIn = leaf.NotAfter.Sub(now).Round(time.Second).String()
+	for _, cert := range chain[1:] {
+		s.Chain = append(s.Chain, &ChainCertificate{
+			Subject:      cert.Subject.String(),
+			Issuer:       cert.Issuer.String(),
+			SerialNumber: formatSerial(cert),
+			NotAfter:     cert.NotAfter,
+		})
+	}
+	roots, err := parseCertificates(bundle.ROOTCA)
+	if err != nil {
+		return err
+	}
+	if len(roots) > 0 {
+		s.RootFingerprint = fingerprint(roots[0])
+	}
+	return nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// formatSerial returns the serial number in colon separated hex like openssl
+func formatSerial(cert *x509.Certificate) string {
+	return colonHex(cert.SerialNumber.Bytes())
+}
+
+// fingerprint returns the SHA-256 fingerprint of the certificate
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return colonHex(sum[:])
+}
+
+func colonHex(data []byte) string {
+	parts := make([]string, 0, len(data))
+	for _, b := range data {
+		parts = append(parts, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+func (a *Agent) Debugger() []debugger.DebugHandler {
+	return []debugger.DebugHandler{
+		{
+			Path:    StatusPath,
+			Handler: a.handleStatus,
+		},
+	}
+}
+
+// handleStatus output the status of the workload certificate, the private key is never exposed
+func (a *Agent) handleStatus(resp http.ResponseWriter, _ *http.Request) {
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(a.Status()); err != nil {
+		log.Errorf("[envoy-mtls] fail to write certificate status, err: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package mtls
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/certificate/fileca"
+	"github.com/polarismesh/polaris-sidecar/internal/mesh/mtls/rotator"
+)
+
+func TestAgent_Status(t *testing.T) {
+	a := &Agent{rotator: rotator.New(rotator.Config{})}
+	assert.Equal(t, "certificate has not been issued yet", a.Status().Error)
+
+	ca, err := fileca.NewSelfSigned("example.com")
+	assert.NoError(t, err)
+	priv, err := certificate.GenerateKey(certificate.KeyAlgorithmECDSAP256, 0)
+	assert.NoError(t, err)
+	identity := certificate.NewIdentity("default", "foo")
+	identity.DNSNames = []string{"foo.default.svc"}
+	csr, err := certificate.GenerateCSR(identity, priv)
+	assert.NoError(t, err)
+	chain, root, err := ca.CreateCertificate(context.Background(), csr, time.Hour)
+	assert.NoError(t, err)
+	a.bundle = &certificate.Bundle{CertChain: []byte(chain), ROOTCA: []byte(root), PrivKey: []byte("secret"),
+		KeyAlgorithm: certificate.KeyAlgorithmECDSAP256}
+
+	resp := httptest.NewRecorder()
+	a.handleStatus(resp, httptest.NewRequest("GET", StatusPath, nil))
+	assert.NotContains(t, resp.Body.String(), "secret")
+	status := &CertificateStatus{}
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), status))
+	assert.Empty(t, status.Error)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/foo", status.SpiffeID)
+	assert.Equal(t, []string{"foo.default.svc"}, status.DNSNames)
+	assert.Equal(t, certificate.KeyAlgorithmECDSAP256, status.KeyAlgorithm)
+	assert.True(t, status.NotAfter.After(time.Now()))
+	assert.Len(t, status.Chain, 1)
+	assert.Equal(t, "CN=polaris-sidecar self signed ca,O=example.com", status.Chain[0].Subject)
+	// SHA-256 指纹为 32 字节
+	assert.Len(t, strings.Split(status.RootFingerprint, ":"), 32)
+	assert.NotEmpty(t, status.SerialNumber)
+}
@@ -34,6 +34,7 @@ import (
 	"google.golang.org/grpc/credentials"
 
 	"github.com/polarismesh/polaris-sidecar/pkg/constants"
+	"github.com/polarismesh/polaris-sidecar/pkg/graceful"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 	polarisApi "github.com/polarismesh/polaris-sidecar/pkg/polaris"
 )
@@ -77,7 +78,7 @@ func (svr *RateLimitServer) Run(ctx context.Context, wg *sync.WaitGroup, errChan
 			return
 		}
 	}
-	ln, err := net.Listen(svr.conf.Network, svr.conf.Address)
+	ln, err := graceful.Listen(svr.conf.Network, svr.conf.Address)
 	if err != nil {
 		log.Errorf("[envoy-rls] create listener error: %v", err)
 		errChan <- err
@@ -128,7 +129,8 @@ func (svr *RateLimitServer) Destroy() {
 				log.Errorf("[envoy-rls] close listener error: %v", err)
 			}
 		}
-		if svr.conf != nil && svr.conf.Network == "unix" {
+		// 升级时 socket 文件由新进程继续使用
+		if svr.conf != nil && svr.conf.Network == "unix" && !graceful.Upgraded() {
 			if err := os.RemoveAll(filepath.Dir(svr.conf.Address)); err != nil {
 				log.Errorf("[envoy-rls] remove unix socket dir error: %v", err)
 			}
@@ -0,0 +1,240 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/utils"
+)
+
+const (
+	// ActionAllow allow the matched queries
+	ActionAllow = "allow"
+	// ActionDeny deny the matched queries
+	ActionDeny = "deny"
+
+	// ReasonClient the client is not allowed to query
+	ReasonClient = "client"
+	// ReasonName the query name is denied for the client
+	ReasonName = "name"
+	// ReasonRecursion the client is not allowed to query the upstream nameservers
+	ReasonRecursion = "recursion"
+
+	wildcard = "*"
+)
+
+var deniedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "polaris_sidecar_dns_denied_total",
+	Help: "Count of the dns queries denied by the access control list",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(deniedCounter)
+}
+
+// CountDenied count the denied query in metrics
+func CountDenied(reason string) {
+	deniedCounter.WithLabelValues(reason).Inc()
+}
+
+// Config the access control list of the dns listener
+type Config struct {
+	Enable bool `yaml:"enable"`
+	// AllowedCidrs the clients allowed to query, empty means all the clients
+	AllowedCidrs []string `yaml:"allowed_cidrs"`
+	// RecursionCidrs the clients allowed to query the upstream nameservers, empty means all the allowed clients
+	RecursionCidrs []string `yaml:"recursion_cidrs"`
+	// Rules the ordered rules of the query names, the first matched rule takes effect,
+	// the queries matching none of the rules are allowed
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Rule allow or deny the query names for the clients
+type Rule struct {
+	// Clients the client cidrs the rule applies to, empty means all the clients
+	Clients []string `yaml:"clients"`
+	// Namespaces the polaris namespaces of the query names, "*" or empty means all the namespaces
+	Namespaces []string `yaml:"namespaces"`
+	// Names the query names, "*.example." matches all the sub domains of example., empty means all the names
+	Names []string `yaml:"names"`
+	// Action allow or deny
+	Action string `yaml:"action"`
+
+	clients []*net.IPNet
+}
+
+// ACL check the clients and the query names
+type ACL struct {
+	allowed   []*net.IPNet
+	recursion []*net.IPNet
+	rules     []*Rule
+	// zones the zones of the resolvers, used to parse the namespaces of the query name
+	zones     []*Zone
+	currentNs string
+}
+
+// Zone the suffix of a resolver and the namespaces it searches for the query name without namespace
+type Zone struct {
+	Suffix          string
+	NamespaceSearch []string
+}
+
+// New build the acl, it returns nil if the config is not enabled
+func New(config *Config, zones []*Zone, currentNs string) (*ACL, error) {
+	if config == nil || !config.Enable {
+		return nil, nil
+	}
+	a := &ACL{zones: zones, currentNs: currentNs}
+	var err error
+	if a.allowed, err = parseCidrs(config.AllowedCidrs); err != nil {
+		return nil, err
+	}
+	if a.recursion, err = parseCidrs(config.RecursionCidrs); err != nil {
+		return nil, err
+	}
+	for idx, rule := range config.Rules {
+		if rule.Action != ActionAllow && rule.Action != ActionDeny {
+			return nil, fmt.Errorf("acl rule %d action should be one of allow, deny", idx)
+		}
+		if rule.clients, err = parseCidrs(rule.Clients); err != nil {
+			return nil, err
+		}
+		for i := range rule.Names {
+			rule.Names[i] = strings.ToLower(dns.Fqdn(rule.Names[i]))
+		}
+		a.rules = append(a.rules, rule)
+	}
+	return a, nil
+}
+
+func parseCidrs(cidrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acl cidr %s: %w", cidr, err)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowClient check whether the client is allowed to query
+func (a *ACL) AllowClient(ip net.IP) bool {
+	if len(a.allowed) == 0 {
+		return true
+	}
+	return ip != nil && containsIP(a.allowed, ip)
+}
+
+// AllowRecursion check whether the client is allowed to query the upstream nameservers
+func (a *ACL) AllowRecursion(ip net.IP) bool {
+	if len(a.recursion) == 0 {
+		return true
+	}
+	return ip != nil && containsIP(a.recursion, ip)
+}
+
+// AllowQuery check the query name against the rules
+func (a *ACL) AllowQuery(ip net.IP, qname string) bool {
+	qname = strings.ToLower(dns.Fqdn(qname))
+	var namespaces []string
+	for _, rule := range a.rules {
+		if len(rule.clients) > 0 && (ip == nil || !containsIP(rule.clients, ip)) {
+			continue
+		}
+		if !matchNames(rule.Names, qname) {
+			continue
+		}
+		if len(rule.Namespaces) > 0 {
+			if namespaces == nil {
+				namespaces = a.namespaces(qname)
+			}
+			// 未携带命名空间的域名可能解析到任一搜索的命名空间，
+			// 拒绝规则命中任一命名空间即生效，允许规则需要覆盖全部命名空间
+			if !matchNamespaces(rule.Namespaces, namespaces, rule.Action == ActionAllow) {
+				continue
+			}
+		}
+		return rule.Action == ActionAllow
+	}
+	return true
+}
+
+// namespaces returns every polaris namespace the resolvers may lookup for the query name
+func (a *ACL) namespaces(qname string) []string {
+	namespaces := make([]string, 0, len(a.zones))
+	for _, zone := range a.zones {
+		for _, svcKey := range utils.ParseQnameCandidates(qname, zone.Suffix, a.currentNs, zone.NamespaceSearch) {
+			namespaces = append(namespaces, svcKey.Namespace)
+		}
+	}
+	return namespaces
+}
+
+func matchNames(patterns []string, qname string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == qname {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(qname, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchNamespaces check whether any of the namespaces is expected, or all of them if matchAll is true
+func matchNamespaces(expects []string, namespaces []string, matchAll bool) bool {
+	if len(namespaces) == 0 {
+		return false
+	}
+	for _, namespace := range namespaces {
+		if containsNamespace(expects, namespace) != matchAll {
+			return !matchAll
+		}
+	}
+	return matchAll
+}
+
+func containsNamespace(expects []string, namespace string) bool {
+	for _, expect := range expects {
+		if expect == wildcard || strings.EqualFold(expect, namespace) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package acl
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACL(t *testing.T) {
+	a, err := New(&Config{Enable: false}, nil, "")
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+	_, err = New(&Config{Enable: true, Rules: []*Rule{{Action: "reject"}}}, nil, "")
+	assert.Error(t, err)
+
+	a, err = New(&Config{
+		Enable:         true,
+		AllowedCidrs:   []string{"10.0.0.0/8"},
+		RecursionCidrs: []string{"10.0.0.0/24"},
+		Rules: []*Rule{
+			{Clients: []string{"10.0.1.0/24"}, Namespaces: []string{"default"}, Action: ActionAllow},
+			{Namespaces: []string{"default", "polaris"}, Action: ActionDeny},
+			{Names: []string{"*.internal.example"}, Action: ActionDeny},
+		},
+	}, []*Zone{{Suffix: "."}, {Suffix: "svc.polaris."}}, "default")
+	assert.NoError(t, err)
+
+	assert.True(t, a.AllowClient(net.ParseIP("10.1.1.1")))
+	assert.False(t, a.AllowClient(net.ParseIP("192.168.0.1")))
+	assert.True(t, a.AllowRecursion(net.ParseIP("10.0.0.1")))
+	assert.False(t, a.AllowRecursion(net.ParseIP("10.1.1.1")))
+
+	trusted, other := net.ParseIP("10.0.1.1"), net.ParseIP("10.0.2.1")
+	assert.True(t, a.AllowQuery(trusted, "foo.default."))
+	assert.False(t, a.AllowQuery(other, "foo.default."))
+	// 后缀与命名空间大小写均不敏感，未携带命名空间时使用当前命名空间
+	assert.False(t, a.AllowQuery(other, "foo.DEFAULT.svc.polaris."))
+	assert.False(t, a.AllowQuery(other, "foo."))
+	assert.False(t, a.AllowQuery(other, "polaris.checker.polaris."))
+	assert.True(t, a.AllowQuery(other, "foo.test."))
+	assert.False(t, a.AllowQuery(trusted, "db.internal.example."))
+	assert.True(t, a.AllowQuery(trusted, "internal.example."))
+}
+
+func TestACL_NamespaceSearch(t *testing.T) {
+	a, err := New(&Config{
+		Enable: true,
+		Rules: []*Rule{
+			{Namespaces: []string{"secret"}, Action: ActionDeny},
+			{Namespaces: []string{"default"}, Action: ActionAllow},
+			{Action: ActionDeny},
+		},
+	}, []*Zone{{Suffix: ".", NamespaceSearch: []string{"current", "secret"}}}, "default")
+	assert.NoError(t, err)
+
+	// 未携带命名空间的域名会依次查找 default 与 secret，需要检查全部命名空间
+	assert.False(t, a.AllowQuery(nil, "foo."))
+	assert.False(t, a.AllowQuery(nil, "foo.secret."))
+	assert.True(t, a.AllowQuery(nil, "foo.default."))
+
+	a, err = New(&Config{
+		Enable: true,
+		Rules: []*Rule{
+			{Namespaces: []string{"default"}, Action: ActionAllow},
+			{Action: ActionDeny},
+		},
+	}, []*Zone{{Suffix: ".", NamespaceSearch: []string{"current", "other"}}}, "default")
+	assert.NoError(t, err)
+	// 允许规则未覆盖 other 命名空间
+	assert.False(t, a.AllowQuery(nil, "foo."))
+	assert.True(t, a.AllowQuery(nil, "foo.default."))
+}
@@ -0,0 +1,127 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// AnswerOrderNone keep the answers in the order returned by the resolver
+	AnswerOrderNone = ""
+	// AnswerOrderRandom shuffle the answers randomly
+	AnswerOrderRandom = "random"
+	// AnswerOrderWeighted shuffle the answers randomly, the answer with greater weight is more likely to be first
+	AnswerOrderWeighted = "weighted"
+	// AnswerOrderClientHash order the answers by the hash of the client ip, so that each client gets a stable order
+	AnswerOrderClientHash = "client_hash"
+)
+
+// IsValidAnswerOrder returns whether the answer order policy is supported
+func IsValidAnswerOrder(policy string) bool {
+	switch policy {
+	case AnswerOrderNone, AnswerOrderRandom, AnswerOrderWeighted, AnswerOrderClientHash:
+		return true
+	}
+	return false
+}
+
+// OrderAnswers reorder the answers in place according to the policy, it should be done before the truncation.
+// weights are aligned with the answers and only used by the weighted policy,
+// the random policy is used instead when weights or clientIP is absent.
+func OrderAnswers(policy string, answers []dns.RR, weights []int, clientIP net.IP) {
+	if len(answers) < 2 {
+		return
+	}
+	switch policy {
+	case AnswerOrderRandom:
+		shuffleAnswers(answers, weights)
+	case AnswerOrderWeighted:
+		if len(weights) != len(answers) {
+			shuffleAnswers(answers, weights)
+			return
+		}
+		weightedShuffleAnswers(answers, weights)
+	case AnswerOrderClientHash:
+		if clientIP == nil {
+			shuffleAnswers(answers, weights)
+			return
+		}
+		clientHashAnswers(answers, clientIP)
+	}
+}
+
+func shuffleAnswers(answers []dns.RR, weights []int) {
+	rand.Shuffle(len(answers), func(i, j int) {
+		answers[i], answers[j] = answers[j], answers[i]
+		if len(weights) == len(answers) {
+			weights[i], weights[j] = weights[j], weights[i]
+		}
+	})
+}
+
+// weightedShuffleAnswers use the Efraimidis-Spirakis algorithm, each answer gets the key u^(1/w)
+// and answers are sorted by the key descending, answers without weight are always placed last
+func weightedShuffleAnswers(answers []dns.RR, weights []int) {
+	type item struct {
+		rr     dns.RR
+		weight int
+		key    float64
+	}
+	items := make([]item, len(answers))
+	for i := range answers {
+		items[i] = item{rr: answers[i], weight: weights[i], key: -1}
+		if weights[i] > 0 {
+			items[i].key = math.Pow(rand.Float64(), 1/float64(weights[i]))
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].key > items[j].key
+	})
+	for i := range items {
+		answers[i] = items[i].rr
+		weights[i] = items[i].weight
+	}
+}
+
+// clientHashAnswers use the rendezvous hashing, the order of the other answers keeps stable
+// when an answer is added or removed
+func clientHashAnswers(answers []dns.RR, clientIP net.IP) {
+	keys := make(map[dns.RR]uint64, len(answers))
+	for _, rr := range answers {
+		h := fnv.New64a()
+		_, _ = h.Write(clientIP)
+		_, _ = h.Write([]byte(rdata(rr)))
+		keys[rr] = h.Sum64()
+	}
+	sort.SliceStable(answers, func(i, j int) bool {
+		return keys[answers[i]] > keys[answers[j]]
+	})
+}
+
+// rdata returns the record data without the header
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
@@ -0,0 +1,96 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildAnswers(ips ...string) []dns.RR {
+	answers := make([]dns.RR, 0, len(ips))
+	for _, ip := range ips {
+		answers = append(answers, &dns.A{
+			Hdr: dns.RR_Header{Name: "foo.default.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+			A:   net.ParseIP(ip),
+		})
+	}
+	return answers
+}
+
+func answerIPs(answers []dns.RR) []string {
+	ips := make([]string, 0, len(answers))
+	for _, rr := range answers {
+		ips = append(ips, rr.(*dns.A).A.String())
+	}
+	return ips
+}
+
+func TestOrderAnswersClientHash(t *testing.T) {
+	clientIP := net.ParseIP("192.168.1.10")
+	first := buildAnswers("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+	OrderAnswers(AnswerOrderClientHash, first, nil, clientIP)
+	second := buildAnswers("10.0.0.4", "10.0.0.3", "10.0.0.2", "10.0.0.1")
+	OrderAnswers(AnswerOrderClientHash, second, nil, clientIP)
+	// 同一个客户端得到稳定的顺序
+	assert.Equal(t, answerIPs(first), answerIPs(second))
+
+	// 删除一个地址不影响其余地址的相对顺序
+	expected := make([]string, 0, 3)
+	for _, ip := range answerIPs(first) {
+		if ip != "10.0.0.2" {
+			expected = append(expected, ip)
+		}
+	}
+	third := buildAnswers("10.0.0.1", "10.0.0.3", "10.0.0.4")
+	OrderAnswers(AnswerOrderClientHash, third, nil, clientIP)
+	assert.Equal(t, expected, answerIPs(third))
+}
+
+func TestOrderAnswersWeighted(t *testing.T) {
+	firstCount := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		answers := buildAnswers("10.0.0.1", "10.0.0.2", "10.0.0.3")
+		weights := []int{100, 0, 900}
+		OrderAnswers(AnswerOrderWeighted, answers, weights, nil)
+		ips := answerIPs(answers)
+		firstCount[ips[0]]++
+		// 权重为 0 的地址总是排在最后
+		assert.Equal(t, "10.0.0.2", ips[2])
+		assert.Equal(t, []int{100, 900}, []int{weights[indexOf(ips, "10.0.0.1")], weights[indexOf(ips, "10.0.0.3")]})
+	}
+	assert.Greater(t, firstCount["10.0.0.3"], firstCount["10.0.0.1"])
+}
+
+func indexOf(values []string, target string) int {
+	for i, v := range values {
+		if v == target {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestOrderAnswersNone(t *testing.T) {
+	answers := buildAnswers("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	OrderAnswers(AnswerOrderNone, answers, nil, nil)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, answerIPs(answers))
+}
@@ -35,17 +35,20 @@ const (
 type ResolverConfig struct {
 	BindIP    string
 	BindPort  uint32
+	Namespace string
 	Resolvers []*ConfigEntry
 }
 
 // ConfigEntry: resolver plugin config entry
 type ConfigEntry struct {
-	Name      string                 `yaml:"name"`
-	Suffix    string                 `yaml:"suffix"`
-	DnsTtl    int                    `yaml:"dns_ttl"`
-	Enable    bool                   `yaml:"enable"`
-	Option    map[string]interface{} `yaml:"option"`
-	Namespace string                 `yaml:"-"`
+	Name   string `yaml:"name"`
+	Suffix string `yaml:"suffix"`
+	DnsTtl int    `yaml:"dns_ttl"`
+	Enable bool   `yaml:"enable"`
+	// AnswerOrder policy to reorder the A/AAAA answers, one of random, weighted, client_hash
+	AnswerOrder string                 `yaml:"answer_order"`
+	Option      map[string]interface{} `yaml:"option"`
+	Namespace   string                 `yaml:"-"`
 }
 
 // NamingResolver resolver interface
@@ -0,0 +1,56 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// WriteSnapshot marshal the value as json and write it to a temporary file then rename it,
+// so that the snapshot file is always complete even if the process crashes while writing
+func WriteSnapshot(path string, value interface{}, perm os.FileMode) error {
+	buf, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmpFile := path + ".tmp"
+	if err = os.WriteFile(tmpFile, buf, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
+
+// ReadSnapshot read the snapshot file and unmarshal it into the value, it returns false if the file not exists
+func ReadSnapshot(path string, value interface{}) (bool, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err = json.Unmarshal(buf, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
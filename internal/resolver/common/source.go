@@ -0,0 +1,79 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/constants"
+)
+
+// RequestFromContext returns the raw dns request carried by the context
+func RequestFromContext(ctx context.Context) *dns.Msg {
+	req, _ := ctx.Value(constants.ContextRequest).(*dns.Msg)
+	return req
+}
+
+// RemoteIPFromContext returns the ip of the client which sends the request
+func RemoteIPFromContext(ctx context.Context) net.IP {
+	addr, _ := ctx.Value(constants.ContextRemoteAddr).(net.Addr)
+	return AddrIP(addr)
+}
+
+// AddrIP returns the ip of the udp or tcp address
+func AddrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// SourceIPFromContext returns the address identifying the query source,
+// the EDNS client subnet is preferred, and the client ip is used when ECS is absent
+func SourceIPFromContext(ctx context.Context) net.IP {
+	if req := RequestFromContext(ctx); req != nil {
+		if subnet := ednsSubnetForRequest(req); subnet != nil && len(subnet.Address) > 0 &&
+			!subnet.Address.IsUnspecified() {
+			return subnet.Address
+		}
+	}
+	return RemoteIPFromContext(ctx)
+}
+
+// EdnsLocalOption returns the data of the EDNS0 local option with the specified code
+func EdnsLocalOption(req *dns.Msg, code uint16) ([]byte, bool) {
+	if req == nil {
+		return nil, false
+	}
+	edns := req.IsEdns0()
+	if edns == nil {
+		return nil, false
+	}
+	for _, o := range edns.Option {
+		if local, ok := o.(*dns.EDNS0_LOCAL); ok && local.Code == code {
+			return local.Data, true
+		}
+	}
+	return nil, false
+}
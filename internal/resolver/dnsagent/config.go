@@ -19,6 +19,11 @@ package dnsagent
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/miekg/dns"
 
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 	"github.com/polarismesh/polaris-sidecar/pkg/utils"
@@ -27,13 +32,72 @@ import (
 type resolverConfig struct {
 	RouteLabelsMap map[string]string `json:"-"`
 	RouteLabels    string            `json:"route_labels"`
+	// NamespaceSearch ordered namespaces to lookup for the qname without namespace,
+	// "current" refers to the sidecar namespace
+	NamespaceSearch []string `json:"namespace_search"`
+	// RouteLabelsFromEdns whether to read the caller labels from the EDNS0 local option
+	RouteLabelsFromEdns bool `json:"route_labels_from_edns"`
+	// RouteLabelsEdnsCode code of the EDNS0 local option, whose data is formatted as "key1:value1,key2:value2"
+	RouteLabelsEdnsCode uint16 `json:"route_labels_edns_code"`
+	// RouteLabelsByCidr caller labels matched by the client subnet or the client ip
+	RouteLabelsByCidr []*cidrLabels `json:"route_labels_by_cidr"`
+	// LocationByCidr caller location matched by the client subnet or the client ip,
+	// which replaces the sidecar location in nearby routing
+	LocationByCidr []*cidrLocation `json:"location_by_cidr"`
+	// ReturnAllInstances return all the routed instances instead of the load balanced one
+	ReturnAllInstances bool `json:"return_all_instances"`
+	// SnapshotPath file to persist the recently resolved answers, which are used when polaris is not available,
+	// empty means disabled
+	SnapshotPath string `json:"snapshot_path"`
+	// SnapshotIntervalSec interval to write the snapshot file
+	SnapshotIntervalSec int `json:"snapshot_interval_sec"`
+	// SnapshotMaxEntries max count of the recently resolved answers to keep
+	SnapshotMaxEntries int `json:"snapshot_max_entries"`
+	// PrefetchEnable cache the resolved instances for the dns ttl and refresh the hot entries ahead of expiry,
+	// it only takes effect when the answers do not depend on the client
+	PrefetchEnable bool `json:"prefetch_enable"`
+	// PrefetchMinHits hits within a ttl for an entry to be refreshed ahead
+	PrefetchMinHits int `json:"prefetch_min_hits"`
+	// PrefetchPercentage remaining ttl percentage to start refreshing
+	PrefetchPercentage int `json:"prefetch_percentage"`
+	// PrefetchWorkers count of the workers to refresh the hot entries
+	PrefetchWorkers int `json:"prefetch_workers"`
+	// PrefetchMaxEntries max count of the cached entries
+	PrefetchMaxEntries int `json:"prefetch_max_entries"`
+	// SvcbAlpnMetadataKey instance metadata key of the comma separated alpn ids in the synthesized SVCB/HTTPS records
+	SvcbAlpnMetadataKey string `json:"svcb_alpn_metadata_key"`
+	// SvcbPortMetadataKey instance metadata key of the port in the synthesized SVCB/HTTPS records,
+	// the instance port is used if it is absent
+	SvcbPortMetadataKey string `json:"svcb_port_metadata_key"`
+}
+
+type cidrLabels struct {
+	CIDR      string            `json:"cidr"`
+	Labels    string            `json:"labels"`
+	ipNet     *net.IPNet        `json:"-"`
+	labelsMap map[string]string `json:"-"`
+}
+
+type cidrLocation struct {
+	CIDR   string     `json:"cidr"`
+	Region string     `json:"region"`
+	Zone   string     `json:"zone"`
+	Campus string     `json:"campus"`
+	ipNet  *net.IPNet `json:"-"`
+}
+
+// hasSourceLabels returns whether the route labels should be computed for each query
+func (c *resolverConfig) hasSourceLabels() bool {
+	return c.RouteLabelsFromEdns || len(c.RouteLabelsByCidr) > 0
+}
+
+// clientDependent returns whether the answers of the same question differ between the clients
+func (c *resolverConfig) clientDependent() bool {
+	return c.hasSourceLabels() || len(c.LocationByCidr) > 0
 }
 
 func parseOptions(options map[string]interface{}) (*resolverConfig, error) {
 	config := &resolverConfig{}
-	if len(options) == 0 {
-		return config, nil
-	}
 	jsonBytes, err := json.Marshal(options)
 	if nil != err {
 		log.Errorf("[dnsagent] fail to marshal options to json, err is %v", err)
@@ -44,5 +108,61 @@ func parseOptions(options map[string]interface{}) (*resolverConfig, error) {
 		return nil, err
 	}
 	config.RouteLabelsMap = utils.ParseLabels(config.RouteLabels)
+	if config.RouteLabelsEdnsCode == 0 {
+		config.RouteLabelsEdnsCode = dns.EDNS0LOCALSTART
+	}
+	if config.SnapshotIntervalSec <= 0 {
+		config.SnapshotIntervalSec = DefaultSnapshotIntervalSec
+	}
+	if config.SnapshotMaxEntries <= 0 {
+		config.SnapshotMaxEntries = DefaultSnapshotMaxEntries
+	}
+	if config.PrefetchMinHits <= 0 {
+		config.PrefetchMinHits = DefaultPrefetchMinHits
+	}
+	if config.PrefetchPercentage <= 0 || config.PrefetchPercentage > 100 {
+		config.PrefetchPercentage = DefaultPrefetchPercentage
+	}
+	if config.PrefetchWorkers <= 0 {
+		config.PrefetchWorkers = DefaultPrefetchWorkers
+	}
+	if config.PrefetchMaxEntries <= 0 {
+		config.PrefetchMaxEntries = DefaultPrefetchMaxEntries
+	}
+	if len(config.SvcbAlpnMetadataKey) == 0 {
+		config.SvcbAlpnMetadataKey = DefaultSvcbAlpnMetadataKey
+	}
+	for _, item := range config.RouteLabelsByCidr {
+		if item.ipNet, err = parseCidr(item.CIDR); nil != err {
+			return nil, err
+		}
+		item.labelsMap = utils.ParseLabels(item.Labels)
+	}
+	for _, item := range config.LocationByCidr {
+		if item.ipNet, err = parseCidr(item.CIDR); nil != err {
+			return nil, err
+		}
+	}
+	// 最长前缀优先匹配
+	sort.SliceStable(config.RouteLabelsByCidr, func(i, j int) bool {
+		return prefixLength(config.RouteLabelsByCidr[i].ipNet) > prefixLength(config.RouteLabelsByCidr[j].ipNet)
+	})
+	sort.SliceStable(config.LocationByCidr, func(i, j int) bool {
+		return prefixLength(config.LocationByCidr[i].ipNet) > prefixLength(config.LocationByCidr[j].ipNet)
+	})
 	return config, nil
 }
+
+func parseCidr(cidr string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if nil != err {
+		log.Errorf("[dnsagent] fail to parse cidr %s, err is %v", cidr, err)
+		return nil, fmt.Errorf("invalid cidr %s: %w", cidr, err)
+	}
+	return ipNet, nil
+}
+
+func prefixLength(ipNet *net.IPNet) int {
+	ones, _ := ipNet.Mask.Size()
+	return ones
+}
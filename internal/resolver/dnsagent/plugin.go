@@ -20,8 +20,10 @@ package dnsagent
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/polarismesh/polaris-go"
@@ -43,11 +45,21 @@ func init() {
 const name = common.PluginNameDnsAgent
 
 type resolverDiscovery struct {
-	consumer  polaris.ConsumerAPI
-	suffix    string
-	dnsTtl    int
-	config    *resolverConfig
-	namespace string
+	consumer polaris.ConsumerAPI
+	router   polaris.RouterAPI
+	// routers router chain without the nearby router, used when the caller location is specified per query
+	routers []string
+	// nearby the nearby settings of the sdk applied to the caller location
+	nearby      nearbyPolicy
+	suffix      string
+	dnsTtl      int
+	answerOrder string
+	config      *resolverConfig
+	namespace   string
+	// recent the recently resolved answers, nil if the snapshot is disabled
+	recent *recentAnswers
+	// prefetcher cache and refresh the hot entries ahead of expiry, nil if disabled
+	prefetcher *prefetcher
 }
 
 // Name will return the name to resolver
@@ -75,14 +87,67 @@ func (r *resolverDiscovery) Initialize(c *common.ConfigEntry) error {
 	if nil != err {
 		return err
 	}
+	if len(r.config.LocationByCidr) > 0 {
+		r.router, err = polarisApi.GetRouterAPI()
+		if nil != err {
+			return err
+		}
+		chain, nearbyConfig := polarisApi.GetRouterChain()
+		r.routers = withoutNearbyRouter(chain)
+		r.nearby = newNearbyPolicy(nearbyConfig)
+	}
 	r.suffix = utils.AddQuota(c.Suffix)
 	r.dnsTtl = c.DnsTtl
+	r.answerOrder = c.AnswerOrder
 	r.namespace = c.Namespace
+	if r.config.PrefetchEnable && r.dnsTtl > 0 {
+		if r.config.clientDependent() {
+			log.Warnf("[dnsagent] prefetch is disabled since the answers depend on the client")
+		} else {
+			if !r.config.ReturnAllInstances && r.router == nil {
+				// 缓存路由后的全部实例，每次查询时再做负载均衡
+				if r.router, err = polarisApi.GetRouterAPI(); nil != err {
+					return err
+				}
+			}
+			r.prefetcher = newPrefetcher(time.Duration(r.dnsTtl)*time.Second, r.config,
+				func(qname string) ([]model.Instance, error) {
+					return r.lookupFromPolaris(context.Background(), qname, r.namespace, false)
+				})
+		}
+	}
+	if len(r.config.SnapshotPath) > 0 {
+		if r.config.clientDependent() {
+			// 应答与客户端相关时，按查询名记录的结果会相互覆盖
+			log.Warnf("[dnsagent] snapshot is disabled since the answers depend on the client")
+		} else {
+			r.recent = newRecentAnswers(r.config.SnapshotMaxEntries,
+				time.Duration(r.config.SnapshotIntervalSec)*time.Second)
+			r.recent.load(r.config.SnapshotPath)
+		}
+	}
 	return nil
 }
 
 // Start the plugin runnable
-func (r *resolverDiscovery) Start(context.Context) {
+func (r *resolverDiscovery) Start(ctx context.Context) {
+	if r.prefetcher != nil {
+		r.prefetcher.start(ctx)
+	}
+	if r.recent != nil {
+		go func() {
+			ticker := time.NewTicker(time.Duration(r.config.SnapshotIntervalSec) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					r.recent.store(r.config.SnapshotPath)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
 	log.Infof("[dnsagent] %s resolver started", name)
 }
 
@@ -92,6 +157,9 @@ func (r *resolverDiscovery) Debugger() []debughttp.DebugHandler {
 
 // Destroy will destroy the resolver on shutdown
 func (r *resolverDiscovery) Destroy() {
+	if nil != r.recent {
+		r.recent.store(r.config.SnapshotPath)
+	}
 	if nil != r.consumer {
 		r.consumer.Destroy()
 		log.Infof("[dnsagent] %s resolver polaris consumerAPI destroyed", name)
@@ -121,23 +189,39 @@ func (r *resolverDiscovery) ServeDNS(ctx context.Context, question dns.Question,
 				log.Error("[dnsagent] decode ip str fail", zap.String("domain", qname), zap.Error(err))
 				return nil
 			}
-			rr := r.markRecord(question, net.IP(ret), nil)
-			msg.Answer = append(msg.Answer, rr)
+			if rr := r.markRecord(question, net.IP(ret), nil); rr != nil {
+				msg.Answer = append(msg.Answer, rr)
+			}
 			log.Infof("[dnsagent] serve dns for %s, protocol: %s, ip: %s", qname, protocol, net.IP(ret).String())
 			return msg
 		}
 	}
 
-	instances, err := r.lookupFromPolaris(qname, r.namespace)
+	instances, err := r.lookup(ctx, qname)
+	if err != nil && r.recent != nil {
+		// 北极星不可用时，使用最近一次的解析结果应答
+		if answers := r.recent.get(question); len(answers) > 0 {
+			log.Infof("[dnsagent] serve dns for %s with recent answers, protocol: %s", qname, protocol)
+			msg.Answer = answers
+			msg.Rcode = dns.RcodeSuccess
+			return msg
+		}
+	}
 	if err != nil || len(instances) == 0 {
 		return nil
 	}
 
 	//do reorder and unique
+	weights := make([]int, 0, len(instances))
 	for i := range instances {
 		ins := instances[i]
 		rr := r.markRecord(question, net.ParseIP(ins.GetHost()), ins)
 		msg.Answer = append(msg.Answer, rr)
+		weights = append(weights, ins.GetWeight())
+	}
+	common.OrderAnswers(r.answerOrder, msg.Answer, weights, common.SourceIPFromContext(ctx))
+	if r.recent != nil {
+		r.recent.put(question, msg.Answer)
 	}
 
 	msg.Rcode = dns.RcodeSuccess
@@ -145,30 +229,183 @@ func (r *resolverDiscovery) ServeDNS(ctx context.Context, question dns.Question,
 	return msg
 }
 
-func (r *resolverDiscovery) lookupFromPolaris(qname string, currentNs string) ([]model.Instance, error) {
-	svcKey := utils.ParseQname(qname, r.suffix, currentNs)
-	if nil == svcKey {
+// lookup returns the cached instances if prefetch is enabled, otherwise lookup from polaris.
+// The prefetcher caches the routed instances before load balancing, so that each query is still balanced.
+func (r *resolverDiscovery) lookup(ctx context.Context, qname string) ([]model.Instance, error) {
+	balance := !r.config.ReturnAllInstances
+	if r.prefetcher == nil {
+		return r.lookupFromPolaris(ctx, qname, r.namespace, balance)
+	}
+	instances, ok := r.prefetcher.get(qname)
+	if !ok {
+		var err error
+		instances, err = r.lookupFromPolaris(ctx, qname, r.namespace, false)
+		if err != nil || len(instances) == 0 {
+			return instances, err
+		}
+		r.prefetcher.put(qname, instances)
+	}
+	if !balance {
+		return instances, nil
+	}
+	return r.loadBalance(instances)
+}
+
+// loadBalance returns the instance chosen by the load balancer from the routed instances of a service
+func (r *resolverDiscovery) loadBalance(instances []model.Instance) ([]model.Instance, error) {
+	lbReq := &polaris.ProcessLoadBalanceRequest{}
+	lbReq.DstInstances = model.NewDefaultServiceInstances(model.ServiceInfo{
+		Service:   instances[0].GetService(),
+		Namespace: instances[0].GetNamespace(),
+	}, instances)
+	lbResp, err := r.router.ProcessLoadBalance(lbReq)
+	if nil != err {
+		log.Errorf("[dnsagent] fail to process load balance of service %s/%s, err: %v",
+			instances[0].GetNamespace(), instances[0].GetService(), err)
+		return nil, err
+	}
+	return lbResp.GetInstances(), nil
+}
+
+// lookupFromPolaris returns the routed instances of the first service found in the candidates, only the one
+// chosen by the load balancer is returned if balance is true
+func (r *resolverDiscovery) lookupFromPolaris(ctx context.Context, qname string,
+	currentNs string, balance bool) ([]model.Instance, error) {
+	svcKeys := utils.ParseQnameCandidates(qname, r.suffix, currentNs, r.config.NamespaceSearch)
+	if len(svcKeys) == 0 {
 		log.Errorf("[dnsagent] fail to parse qname %s, namespace: %s, suffix:%s", qname, currentNs, r.suffix)
 		return nil, nil
 	}
-	request := &polaris.GetOneInstanceRequest{}
-	request.Namespace = svcKey.Namespace
-	request.Service = svcKey.Service
-	if len(r.config.RouteLabelsMap) > 0 {
-		request.SourceService = &model.ServiceInfo{Metadata: r.config.RouteLabelsMap}
+	routeLabels := r.routeLabels(ctx)
+	location := r.sourceLocation(ctx)
+	var lastErr error
+	for _, svcKey := range svcKeys {
+		if location != nil {
+			instances, err := r.lookupNearby(svcKey, routeLabels, location, balance)
+			if nil != err {
+				if !isServiceNotFound(err) {
+					return nil, err
+				}
+				lastErr = err
+				continue
+			}
+			if len(instances) > 0 {
+				return instances, nil
+			}
+			continue
+		}
+		request := &polaris.GetOneInstanceRequest{}
+		request.Namespace = svcKey.Namespace
+		request.Service = svcKey.Service
+		if len(routeLabels) > 0 {
+			request.SourceService = &model.ServiceInfo{Metadata: routeLabels}
+		}
+		resp, err := r.getInstances(request, balance)
+		if nil != err {
+			// 仅在服务不存在时继续查找搜索列表中的下一个命名空间，超时等错误直接返回，避免解析到其他命名空间的同名服务
+			if !isServiceNotFound(err) {
+				log.Errorf("[dnsagent] fail to lookup service %s, err: %v", *svcKey, err)
+				return nil, err
+			}
+			log.Debugf("[dnsagent] service %s not found, err: %v", *svcKey, err)
+			lastErr = err
+			continue
+		}
+		if len(resp) == 0 {
+			log.Infof("[dnsagent] lookup service %s got empty instances, req:%s", *svcKey, utils.JsonString(request))
+			continue
+		}
+		log.Infof("[dnsagent] lookup service %s success, resp: %v, req:%s", *svcKey, utils.JsonString(resp),
+			utils.JsonString(request))
+		return resp, nil
+	}
+	if lastErr != nil {
+		namespaces := make([]string, 0, len(svcKeys))
+		for _, svcKey := range svcKeys {
+			namespaces = append(namespaces, svcKey.Namespace)
+		}
+		log.Errorf("[dnsagent] fail to lookup qname %s in namespaces %v, err: %v", qname, namespaces, lastErr)
+	}
+	return nil, lastErr
+}
+
+// isServiceNotFound returns whether the error means the service or its instances do not exist
+func isServiceNotFound(err error) bool {
+	var sdkErr model.SDKError
+	if !errors.As(err, &sdkErr) {
+		return false
+	}
+	code := sdkErr.ErrorCode()
+	return code == model.ErrCodeServiceNotFound || code == model.ErrCodeAPIInstanceNotFound
+}
+
+// getInstances returns the load balanced instance, or all the routed instances if balance is false
+func (r *resolverDiscovery) getInstances(request *polaris.GetOneInstanceRequest,
+	balance bool) ([]model.Instance, error) {
+	if balance {
+		resp, err := r.consumer.GetOneInstance(request)
+		if nil != err {
+			return nil, err
+		}
+		return resp.GetInstances(), nil
 	}
-	resp, err := r.consumer.GetOneInstance(request)
+	allReq := &polaris.GetInstancesRequest{}
+	allReq.Namespace = request.Namespace
+	allReq.Service = request.Service
+	allReq.SourceService = request.SourceService
+	resp, err := r.consumer.GetInstances(allReq)
 	if nil != err {
-		log.Errorf("[dnsagent] fail to lookup service %s, err: %v, req:%s", *svcKey, err, utils.JsonString(request))
 		return nil, err
 	}
-	log.Infof("[dnsagent] lookup service %s success, resp: %v, req:%s", *svcKey, utils.JsonString(resp.GetInstances()),
-		utils.JsonString(request))
 	return resp.GetInstances(), nil
 }
 
+// lookupNearby performs the routing with the caller location instead of the sidecar location
+func (r *resolverDiscovery) lookupNearby(svcKey *model.ServiceKey, routeLabels map[string]string,
+	location *cidrLocation, balance bool) ([]model.Instance, error) {
+	allReq := &polaris.GetAllInstancesRequest{}
+	allReq.Namespace = svcKey.Namespace
+	allReq.Service = svcKey.Service
+	allResp, err := r.consumer.GetAllInstances(allReq)
+	if nil != err {
+		log.Debugf("[dnsagent] fail to get all instances of service %s, err: %v", *svcKey, err)
+		return nil, err
+	}
+	routeReq := &polaris.ProcessRoutersRequest{}
+	routeReq.Routers = r.routers
+	routeReq.SourceService = model.ServiceInfo{Metadata: routeLabels}
+	routeReq.DstInstances = allResp
+	routeResp, err := r.router.ProcessRouters(routeReq)
+	if nil != err {
+		log.Errorf("[dnsagent] fail to process routers of service %s, err: %v", *svcKey, err)
+		return nil, err
+	}
+	instances := filterByLocation(routeResp.GetInstances(), location, r.nearby)
+	if len(instances) == 0 {
+		log.Infof("[dnsagent] lookup service %s got empty instances after routing", *svcKey)
+		return nil, nil
+	}
+	if !balance {
+		return instances, nil
+	}
+	lbReq := &polaris.ProcessLoadBalanceRequest{}
+	lbReq.DstInstances = model.NewDefaultServiceInstances(model.ServiceInfo{
+		Service:   svcKey.Service,
+		Namespace: svcKey.Namespace,
+	}, instances)
+	lbResp, err := r.router.ProcessLoadBalance(lbReq)
+	if nil != err {
+		log.Errorf("[dnsagent] fail to process load balance of service %s, err: %v", *svcKey, err)
+		return nil, err
+	}
+	log.Infof("[dnsagent] lookup service %s nearby %s/%s/%s success, resp: %v", *svcKey, location.Region,
+		location.Zone, location.Campus, utils.JsonString(lbResp.GetInstances()))
+	return lbResp.GetInstances(), nil
+}
+
 func encodeIPAsFqdn(ip net.IP, svcKey model.ServiceKey) string {
-	respDomain := fmt.Sprintf("%s._addr.%s.%s", hex.EncodeToString(ip), svcKey.Service, svcKey.Namespace)
+	respDomain := fmt.Sprintf("%s._addr.%s.%s", hex.EncodeToString(ip), utils.EscapeLabel(svcKey.Service),
+		svcKey.Namespace)
 	return dns.Fqdn(respDomain)
 }
 
@@ -196,6 +433,11 @@ func (r *resolverDiscovery) markRecord(question dns.Question, address net.IP, in
 			Port:     uint16(ins.GetPort()),
 			Target:   encodeIPAsFqdn(address, ins.GetInstanceKey().ServiceKey),
 		}
+	case dns.TypeSVCB, dns.TypeHTTPS:
+		if ins == nil {
+			return rr
+		}
+		rr = r.svcbRecord(question, address, ins)
 	case dns.TypeAAAA:
 		rr = &dns.AAAA{
 			Hdr:  dns.RR_Header{Name: qname, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: uint32(r.dnsTtl)},
@@ -18,13 +18,27 @@
 package dnsagent
 
 import (
+	"context"
 	"encoding/hex"
 	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/polarismesh/polaris-go"
+	"github.com/polarismesh/polaris-go/pkg/config"
 	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/polarismesh/polaris-go/pkg/model/pb"
+	apimodel "github.com/polarismesh/specification/source/go/api/v1/model"
+	"github.com/polarismesh/specification/source/go/api/v1/service_manage"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/constants"
 )
 
 func Test_encodeIPAsFqdn(t *testing.T) {
@@ -109,3 +123,280 @@ func Test_encodeIPAsFqdn(t *testing.T) {
 		})
 	}
 }
+
+func Test_routeLabels(t *testing.T) {
+	config, err := parseOptions(map[string]interface{}{
+		"route_labels":           "env:prod,zone:default",
+		"route_labels_from_edns": true,
+		"route_labels_by_cidr": []map[string]interface{}{
+			{"cidr": "10.0.0.0/8", "labels": "zone:wide"},
+			{"cidr": "10.1.0.0/16", "labels": "zone:narrow,app:foo"},
+		},
+	})
+	assert.NoError(t, err)
+	r := &resolverDiscovery{config: config}
+
+	req := &dns.Msg{}
+	req.SetQuestion("foo.default.", dns.TypeA)
+	ctx := context.WithValue(context.Background(), constants.ContextRequest, req)
+	ctx = context.WithValue(ctx, constants.ContextRemoteAddr, &net.UDPAddr{IP: net.ParseIP("10.1.2.3")})
+	assert.Equal(t, map[string]string{"env": "prod", "zone": "narrow", "app": "foo"}, r.routeLabels(ctx))
+
+	// ECS 优先于客户端地址
+	req.SetEdns0(dns.DefaultMsgSize, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("10.2.0.0").To4(),
+	})
+	assert.Equal(t, map[string]string{"env": "prod", "zone": "wide"}, r.routeLabels(ctx))
+
+	// EDNS0 local option 优先级最高
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: dns.EDNS0LOCALSTART, Data: []byte("zone:edns")})
+	assert.Equal(t, map[string]string{"env": "prod", "zone": "edns"}, r.routeLabels(ctx))
+}
+
+func newLocatedInstance(host, region, zone, campus string) model.Instance {
+	svcKey := &model.ServiceKey{Namespace: "default", Service: "foo"}
+	return pb.NewInstanceInProto(&service_manage.Instance{
+		Service:   wrapperspb.String(svcKey.Service),
+		Namespace: wrapperspb.String(svcKey.Namespace),
+		Host:      wrapperspb.String(host),
+		Port:      wrapperspb.UInt32(8080),
+		Location: &apimodel.Location{
+			Region: wrapperspb.String(region),
+			Zone:   wrapperspb.String(zone),
+			Campus: wrapperspb.String(campus),
+		},
+	}, svcKey, nil)
+}
+
+func Test_filterByLocation(t *testing.T) {
+	instances := []model.Instance{
+		newLocatedInstance("10.0.0.1", "south", "gz", "gz-1"),
+		newLocatedInstance("10.0.0.2", "south", "gz", "gz-2"),
+		newLocatedInstance("10.0.0.3", "south", "sz", "sz-1"),
+		newLocatedInstance("10.0.0.4", "north", "bj", "bj-1"),
+	}
+	hosts := func(instances []model.Instance) []string {
+		ret := make([]string, 0, len(instances))
+		for _, ins := range instances {
+			ret = append(ret, ins.GetHost())
+		}
+		return ret
+	}
+	gz := &cidrLocation{Region: "south", Zone: "gz", Campus: "gz-1"}
+	assert.Equal(t, []string{"10.0.0.1"},
+		hosts(filterByLocation(instances, gz, nearbyPolicy{matchLevel: matchLevelCampus})))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"},
+		hosts(filterByLocation(instances, gz, nearbyPolicy{matchLevel: matchLevelZone})))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		hosts(filterByLocation(instances, gz, nearbyPolicy{matchLevel: matchLevelRegion})))
+	// 当前园区没有实例时降级到可用区
+	hz := &cidrLocation{Region: "south", Zone: "sz", Campus: "sz-9"}
+	assert.Equal(t, []string{"10.0.0.3"},
+		hosts(filterByLocation(instances, hz, nearbyPolicy{matchLevel: matchLevelCampus})))
+	// 严格就近时不降级
+	assert.Empty(t, filterByLocation(instances, hz, nearbyPolicy{matchLevel: matchLevelCampus, strict: true}))
+	// 最多降级到可用区
+	sh := &cidrLocation{Region: "east", Zone: "sh", Campus: "sh-1"}
+	assert.Empty(t, filterByLocation(instances, sh,
+		nearbyPolicy{matchLevel: matchLevelCampus, maxMatchLevel: matchLevelRegion}))
+	// 完全不匹配且允许降级到全部时返回全部实例
+	west := &cidrLocation{Region: "west"}
+	assert.Len(t, filterByLocation(instances, west, nearbyPolicy{}), len(instances))
+}
+
+func Test_withoutNearbyRouter(t *testing.T) {
+	assert.Equal(t, []string{config.DefaultServiceRouterRuleBased},
+		withoutNearbyRouter([]string{config.DefaultServiceRouterRuleBased, config.DefaultServiceRouterNearbyBased}))
+	// 只配置了就近路由时仍然过滤不健康和隔离的实例
+	assert.Equal(t, []string{config.DefaultServiceRouterFilterOnly},
+		withoutNearbyRouter([]string{config.DefaultServiceRouterNearbyBased}))
+}
+
+func Test_recentAnswers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	recent := newRecentAnswers(2, 0)
+	question := dns.Question{Name: "foo.default.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	recent.put(question, []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+		A:   net.ParseIP("127.0.0.1").To4(),
+	}})
+	recent.put(dns.Question{Name: "bar.default.", Qtype: dns.TypeA}, nil)
+	recent.entries[recentKey("bar.default.", dns.TypeA)].UpdateTime = 0
+	// 超过容量时淘汰最早的结果
+	recent.put(dns.Question{Name: "baz.default.", Qtype: dns.TypeA}, nil)
+	assert.Len(t, recent.entries, 2)
+	assert.NotContains(t, recent.entries, recentKey("bar.default.", dns.TypeA))
+	recent.store(path)
+
+	// 重启后从快照恢复，查询名大小写不敏感
+	restored := newRecentAnswers(2, time.Minute)
+	restored.load(path)
+	answers := restored.get(dns.Question{Name: "FOO.default.", Qtype: dns.TypeA})
+	assert.Len(t, answers, 1)
+	assert.Equal(t, "FOO.default.", answers[0].Header().Name)
+	assert.Equal(t, "127.0.0.1", answers[0].(*dns.A).A.String())
+	assert.Nil(t, restored.get(dns.Question{Name: "foo.default.", Qtype: dns.TypeAAAA}))
+
+	// 刷新周期内不替换结果
+	restored.entries[recentKey("foo.default.", dns.TypeA)].UpdateTime = time.Now().Unix()
+	restored.put(dns.Question{Name: "foo.default.", Qtype: dns.TypeA}, nil)
+	assert.Len(t, restored.get(dns.Question{Name: "foo.default.", Qtype: dns.TypeA}), 1)
+}
+
+func Test_prefetcher(t *testing.T) {
+	var lookups int32
+	config, _ := parseOptions(map[string]interface{}{"prefetch_enable": true, "prefetch_min_hits": 2,
+		"prefetch_percentage": 50})
+	p := newPrefetcher(10*time.Second, config, func(qname string) ([]model.Instance, error) {
+		atomic.AddInt32(&lookups, 1)
+		return []model.Instance{newLocatedInstance("127.0.0.2", "", "", "")}, nil
+	})
+	var nowMutex sync.Mutex
+	now := time.Now()
+	p.now = func() time.Time {
+		nowMutex.Lock()
+		defer nowMutex.Unlock()
+		return now
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.start(ctx)
+
+	_, ok := p.get("foo.default.")
+	assert.False(t, ok)
+	p.put("foo.default.", []model.Instance{newLocatedInstance("127.0.0.1", "", "", "")})
+
+	// 剩余 ttl 超过一半时不刷新
+	instances, ok := p.get("FOO.default.")
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1", instances[0].GetHost())
+	_, _ = p.get("foo.default.")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&lookups))
+
+	// 热点条目在过期前后台刷新
+	nowMutex.Lock()
+	now = now.Add(6 * time.Second)
+	nowMutex.Unlock()
+	_, _ = p.get("foo.default.")
+	assert.Eventually(t, func() bool {
+		instances, ok := p.get("foo.default.")
+		return ok && instances[0].GetHost() == "127.0.0.2"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+}
+
+type fakeRouter struct {
+	polaris.RouterAPI
+	balanced int
+}
+
+// ProcessLoadBalance choose the instances in turn
+func (f *fakeRouter) ProcessLoadBalance(req *polaris.ProcessLoadBalanceRequest) (*model.OneInstanceResponse, error) {
+	instances := req.DstInstances.GetInstances()
+	resp := &model.OneInstanceResponse{}
+	resp.Instances = []model.Instance{instances[f.balanced%len(instances)]}
+	f.balanced++
+	return resp, nil
+}
+
+func Test_lookupPrefetchedBalance(t *testing.T) {
+	config, _ := parseOptions(map[string]interface{}{"prefetch_enable": true})
+	router := &fakeRouter{}
+	r := &resolverDiscovery{config: config, router: router}
+	r.prefetcher = newPrefetcher(10*time.Second, config, nil)
+	r.prefetcher.put("foo.default.", []model.Instance{newLocatedInstance("127.0.0.1", "", "", ""),
+		newLocatedInstance("127.0.0.2", "", "", "")})
+
+	// 缓存负载均衡前的实例，每次查询分别做负载均衡
+	first, err := r.lookup(context.Background(), "foo.default.")
+	assert.NoError(t, err)
+	second, err := r.lookup(context.Background(), "foo.default.")
+	assert.NoError(t, err)
+	assert.Len(t, first, 1)
+	assert.Len(t, second, 1)
+	assert.NotEqual(t, first[0].GetHost(), second[0].GetHost())
+	assert.Equal(t, 2, router.balanced)
+
+	r.config.ReturnAllInstances = true
+	all, err := r.lookup(context.Background(), "foo.default.")
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+	assert.Equal(t, 2, router.balanced)
+}
+
+func Test_svcbRecord(t *testing.T) {
+	config, err := parseOptions(map[string]interface{}{"svcb_port_metadata_key": "https_port"})
+	assert.NoError(t, err)
+	r := &resolverDiscovery{config: config, dnsTtl: 10}
+	svcKey := &model.ServiceKey{Namespace: "default", Service: "foo"}
+	ins := pb.NewInstanceInProto(&service_manage.Instance{
+		Service:   wrapperspb.String(svcKey.Service),
+		Namespace: wrapperspb.String(svcKey.Namespace),
+		Host:      wrapperspb.String("10.0.0.1"),
+		Port:      wrapperspb.UInt32(8080),
+		Metadata:  map[string]string{"alpn": "h2, http/1.1", "https_port": "8443"},
+	}, svcKey, nil)
+
+	question := dns.Question{Name: "foo.default.", Qtype: dns.TypeHTTPS}
+	rr := r.markRecord(question, net.ParseIP("10.0.0.1"), ins)
+	https, ok := rr.(*dns.HTTPS)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), https.Priority)
+	assert.Equal(t, encodeIPAsFqdn(net.ParseIP("10.0.0.1"), *svcKey), https.Target)
+	assert.Equal(t, "alpn=\"h2,http/1.1\" port=\"8443\" ipv4hint=\"10.0.0.1\"", svcbParams(https.Value))
+
+	// 不指定端口元数据时使用实例端口
+	r.config.SvcbPortMetadataKey = ""
+	question.Qtype = dns.TypeSVCB
+	svcb, ok := r.markRecord(question, net.ParseIP("10.0.0.1"), ins).(*dns.SVCB)
+	assert.True(t, ok)
+	assert.Equal(t, dns.TypeSVCB, svcb.Hdr.Rrtype)
+	assert.Equal(t, "alpn=\"h2,http/1.1\" port=\"8080\" ipv4hint=\"10.0.0.1\"", svcbParams(svcb.Value))
+
+	assert.Nil(t, r.markRecord(question, net.ParseIP("10.0.0.1"), nil))
+}
+
+func svcbParams(values []dns.SVCBKeyValue) string {
+	params := make([]string, 0, len(values))
+	for _, value := range values {
+		params = append(params, value.Key().String()+"=\""+value.String()+"\"")
+	}
+	return strings.Join(params, " ")
+}
+
+type fakeConsumer struct {
+	polaris.ConsumerAPI
+	errs      map[string]error
+	instances map[string][]model.Instance
+}
+
+func (f *fakeConsumer) GetOneInstance(req *polaris.GetOneInstanceRequest) (*model.OneInstanceResponse, error) {
+	if err := f.errs[req.Namespace]; err != nil {
+		return nil, err
+	}
+	resp := &model.OneInstanceResponse{}
+	resp.Instances = f.instances[req.Namespace]
+	return resp, nil
+}
+
+func Test_lookupFromPolarisNamespaceSearch(t *testing.T) {
+	config, _ := parseOptions(map[string]interface{}{"namespace_search": []interface{}{"current", "shared"}})
+	consumer := &fakeConsumer{
+		errs:      map[string]error{"default": model.NewSDKError(model.ErrCodeAPIInstanceNotFound, nil, "not found")},
+		instances: map[string][]model.Instance{"shared": {newLocatedInstance("127.0.0.1", "", "", "")}},
+	}
+	r := &resolverDiscovery{config: config, consumer: consumer, suffix: "."}
+
+	// 服务不存在时查找下一个命名空间
+	instances, err := r.lookupFromPolaris(context.Background(), "foo.", "default", true)
+	assert.NoError(t, err)
+	assert.Len(t, instances, 1)
+
+	// 超时等其他错误直接返回，不使用其他命名空间的同名服务
+	consumer.errs["default"] = model.NewSDKError(model.ErrCodeAPITimeoutError, nil, "timeout")
+	instances, err = r.lookupFromPolaris(context.Background(), "foo.", "default", true)
+	assert.Error(t, err)
+	assert.Empty(t, instances)
+}
@@ -0,0 +1,151 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package dnsagent
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polarismesh/polaris-go/pkg/model"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	// DefaultPrefetchMinHits default hits within a ttl for an entry to be refreshed ahead
+	DefaultPrefetchMinHits = 3
+	// DefaultPrefetchPercentage default remaining ttl percentage to start refreshing
+	DefaultPrefetchPercentage = 10
+	// DefaultPrefetchWorkers default count of the workers to refresh the hot entries
+	DefaultPrefetchWorkers = 4
+	// DefaultPrefetchMaxEntries default max count of the cached entries
+	DefaultPrefetchMaxEntries = 4096
+)
+
+type prefetchEntry struct {
+	instances []model.Instance
+	expireAt  time.Time
+	// hits count of hits since the last refresh
+	hits       int
+	refreshing bool
+}
+
+// prefetcher cache the routed instances before load balancing for a ttl, and refresh the hot entries in the background
+// shortly before they expire, so that the popular names always get a warm answer
+type prefetcher struct {
+	mutex      sync.Mutex
+	entries    map[string]*prefetchEntry
+	ttl        time.Duration
+	minHits    int
+	percentage int
+	maxEntries int
+	workers    int
+	tasks      chan string
+	lookup     func(qname string) ([]model.Instance, error)
+	now        func() time.Time
+}
+
+func newPrefetcher(ttl time.Duration, config *resolverConfig,
+	lookup func(qname string) ([]model.Instance, error)) *prefetcher {
+	return &prefetcher{
+		entries:    map[string]*prefetchEntry{},
+		ttl:        ttl,
+		minHits:    config.PrefetchMinHits,
+		percentage: config.PrefetchPercentage,
+		maxEntries: config.PrefetchMaxEntries,
+		workers:    config.PrefetchWorkers,
+		tasks:      make(chan string, config.PrefetchWorkers),
+		lookup:     lookup,
+		now:        time.Now,
+	}
+}
+
+// start run the refresh workers until the context is done
+func (p *prefetcher) start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for {
+				select {
+				case qname := <-p.tasks:
+					p.refresh(qname)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (p *prefetcher) refresh(qname string) {
+	instances, err := p.lookup(qname)
+	if err != nil || len(instances) == 0 {
+		// 刷新失败时保留旧的结果直到过期
+		p.mutex.Lock()
+		if entry, ok := p.entries[qname]; ok {
+			entry.refreshing = false
+		}
+		p.mutex.Unlock()
+		return
+	}
+	log.Debugf("[dnsagent] prefetched %s with %d instances", qname, len(instances))
+	p.put(qname, instances)
+}
+
+// get returns the cached instances, and submit the refresh task if the entry is hot and about to expire
+func (p *prefetcher) get(qname string) ([]model.Instance, bool) {
+	qname = strings.ToLower(qname)
+	now := p.now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry, ok := p.entries[qname]
+	if !ok || !now.Before(entry.expireAt) {
+		return nil, false
+	}
+	entry.hits++
+	if !entry.refreshing && entry.hits >= p.minHits &&
+		entry.expireAt.Sub(now) <= p.ttl*time.Duration(p.percentage)/100 {
+		select {
+		case p.tasks <- qname:
+			entry.refreshing = true
+		default:
+			// 刷新任务队列已满，等待下一次命中时再提交
+		}
+	}
+	return entry.instances, true
+}
+
+// put cache the instances for a ttl, the expired entries are evicted when the cache is full
+func (p *prefetcher) put(qname string, instances []model.Instance) {
+	qname = strings.ToLower(qname)
+	now := p.now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.entries[qname]; !ok && len(p.entries) >= p.maxEntries {
+		for key, entry := range p.entries {
+			if !now.Before(entry.expireAt) {
+				delete(p.entries, key)
+			}
+		}
+		if len(p.entries) >= p.maxEntries {
+			return
+		}
+	}
+	p.entries[qname] = &prefetchEntry{instances: instances, expireAt: now.Add(p.ttl)}
+}
@@ -0,0 +1,170 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package dnsagent
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	// DefaultSnapshotIntervalSec default interval to write the recently resolved answers to the snapshot file
+	DefaultSnapshotIntervalSec = 60
+	// DefaultSnapshotMaxEntries default max count of the recently resolved answers to keep
+	DefaultSnapshotMaxEntries = 1024
+)
+
+// recentEntry the answers of a question persisted in the snapshot file
+type recentEntry struct {
+	Name    string   `json:"name"`
+	Qtype   uint16   `json:"qtype"`
+	Answers []string `json:"answers"`
+	// UpdateTime unix seconds when the answers are resolved from polaris
+	UpdateTime int64 `json:"update_time"`
+}
+
+// recentAnswers keep the recently resolved answers, they are persisted to the snapshot file periodically
+// and used as the fallback when polaris is not available, e.g. the sidecar restarts during a polaris outage
+type recentAnswers struct {
+	mutex      sync.RWMutex
+	maxEntries int
+	// refreshInterval the entry is replaced at most once per interval, so that most of the queries
+	// only take the read lock
+	refreshInterval time.Duration
+	entries         map[string]*recentEntry
+	dirty           bool
+}
+
+func newRecentAnswers(maxEntries int, refreshInterval time.Duration) *recentAnswers {
+	return &recentAnswers{
+		maxEntries:      maxEntries,
+		refreshInterval: refreshInterval,
+		entries:         map[string]*recentEntry{},
+	}
+}
+
+func recentKey(name string, qtype uint16) string {
+	return dns.TypeToString[qtype] + "/" + strings.ToLower(name)
+}
+
+// put replace the answers of the question with the live data, it is skipped if the entry was replaced
+// within the refresh interval
+func (c *recentAnswers) put(question dns.Question, answers []dns.RR) {
+	key := recentKey(question.Name, question.Qtype)
+	now := time.Now()
+	c.mutex.RLock()
+	current, ok := c.entries[key]
+	c.mutex.RUnlock()
+	if ok && now.Sub(time.Unix(current.UpdateTime, 0)) < c.refreshInterval {
+		return
+	}
+	entry := &recentEntry{
+		Name:       question.Name,
+		Qtype:      question.Qtype,
+		Answers:    make([]string, 0, len(answers)),
+		UpdateTime: now.Unix(),
+	}
+	for _, rr := range answers {
+		entry.Answers = append(entry.Answers, rr.String())
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+	c.entries[key] = entry
+	c.dirty = true
+}
+
+func (c *recentAnswers) evictOldest() {
+	var oldestKey string
+	var oldest *recentEntry
+	for key, entry := range c.entries {
+		if oldest == nil || entry.UpdateTime < oldest.UpdateTime {
+			oldestKey, oldest = key, entry
+		}
+	}
+	delete(c.entries, oldestKey)
+}
+
+// get returns the last resolved answers of the question
+func (c *recentAnswers) get(question dns.Question) []dns.RR {
+	c.mutex.RLock()
+	entry, ok := c.entries[recentKey(question.Name, question.Qtype)]
+	c.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+	answers := make([]dns.RR, 0, len(entry.Answers))
+	for _, value := range entry.Answers {
+		rr, err := dns.NewRR(value)
+		if err != nil || rr == nil {
+			log.Errorf("[dnsagent] fail to parse recent answer %s, err: %v", value, err)
+			continue
+		}
+		rr.Header().Name = question.Name
+		answers = append(answers, rr)
+	}
+	return answers
+}
+
+// load restore the recently resolved answers from the snapshot file
+func (c *recentAnswers) load(path string) {
+	var entries []*recentEntry
+	exists, err := common.ReadSnapshot(path, &entries)
+	if err != nil {
+		log.Errorf("[dnsagent] fail to read snapshot %s, err: %v", path, err)
+		return
+	}
+	if !exists {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, entry := range entries {
+		if len(c.entries) >= c.maxEntries {
+			break
+		}
+		c.entries[recentKey(entry.Name, entry.Qtype)] = entry
+	}
+	log.Infof("[dnsagent] restored %d recent answers from snapshot %s", len(c.entries), path)
+}
+
+// store write the recently resolved answers to the snapshot file if changed
+func (c *recentAnswers) store(path string) {
+	c.mutex.Lock()
+	if !c.dirty {
+		c.mutex.Unlock()
+		return
+	}
+	entries := make([]*recentEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	c.dirty = false
+	c.mutex.Unlock()
+	if err := common.WriteSnapshot(path, entries, 0644); err != nil {
+		log.Errorf("[dnsagent] fail to write snapshot %s, err: %v", path, err)
+	}
+}
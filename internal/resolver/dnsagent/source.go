@@ -0,0 +1,213 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package dnsagent
+
+import (
+	"context"
+	"net"
+
+	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/polarismesh/polaris-go/pkg/model"
+
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+	"github.com/polarismesh/polaris-sidecar/pkg/utils"
+)
+
+// routeLabels returns the caller labels passed to the polaris router for this query,
+// order of priority is: route_labels < route_labels_by_cidr < EDNS0 local option
+func (r *resolverDiscovery) routeLabels(ctx context.Context) map[string]string {
+	if !r.config.hasSourceLabels() {
+		return r.config.RouteLabelsMap
+	}
+	labels := make(map[string]string, len(r.config.RouteLabelsMap))
+	for k, v := range r.config.RouteLabelsMap {
+		labels[k] = v
+	}
+	if item := matchCidrLabels(r.config.RouteLabelsByCidr, common.SourceIPFromContext(ctx)); item != nil {
+		for k, v := range item.labelsMap {
+			labels[k] = v
+		}
+	}
+	if r.config.RouteLabelsFromEdns {
+		if data, ok := common.EdnsLocalOption(common.RequestFromContext(ctx), r.config.RouteLabelsEdnsCode); ok {
+			for k, v := range utils.ParseLabels(string(data)) {
+				labels[k] = v
+			}
+		}
+	}
+	log.Debugf("[dnsagent] route labels for query: %v", labels)
+	return labels
+}
+
+// matchCidrLabels returns the first item containing the ip, items are sorted by the prefix length
+func matchCidrLabels(items []*cidrLabels, ip net.IP) *cidrLabels {
+	if ip == nil {
+		return nil
+	}
+	for _, item := range items {
+		if item.ipNet.Contains(ip) {
+			return item
+		}
+	}
+	return nil
+}
+
+// sourceLocation returns the caller location matched by the client subnet or the client ip
+func (r *resolverDiscovery) sourceLocation(ctx context.Context) *cidrLocation {
+	if len(r.config.LocationByCidr) == 0 {
+		return nil
+	}
+	ip := common.SourceIPFromContext(ctx)
+	if ip == nil {
+		return nil
+	}
+	for _, item := range r.config.LocationByCidr {
+		if item.ipNet.Contains(ip) {
+			return item
+		}
+	}
+	return nil
+}
+
+const (
+	matchLevelCampus = "campus"
+	matchLevelZone   = "zone"
+	matchLevelRegion = "region"
+)
+
+// locationLevels the match levels from the narrowest to the widest
+var locationLevels = []string{matchLevelCampus, matchLevelZone, matchLevelRegion}
+
+// nearbyPolicy the nearby settings of the sdk, consumer.serviceRouter.plugin.nearbyBasedRouter
+type nearbyPolicy struct {
+	// matchLevel the level to match first, default zone
+	matchLevel string
+	// maxMatchLevel the widest level to degrade to, empty means all instances
+	maxMatchLevel string
+	// strict do not degrade from the match level
+	strict bool
+}
+
+func newNearbyPolicy(nearbyConfig config.NearbyConfig) nearbyPolicy {
+	if nearbyConfig == nil {
+		return nearbyPolicy{}
+	}
+	return nearbyPolicy{
+		matchLevel:    nearbyConfig.GetMatchLevel(),
+		maxMatchLevel: nearbyConfig.GetMaxMatchLevel(),
+		strict:        nearbyConfig.IsStrictNearby(),
+	}
+}
+
+// levels returns the levels to match in order, and whether to degrade to all instances if nothing matched
+func (p nearbyPolicy) levels() ([]string, bool) {
+	start := levelIndex(p.matchLevel, 1)
+	end := levelIndex(p.maxMatchLevel, len(locationLevels))
+	if p.strict || end < start {
+		end = start
+	}
+	if end >= len(locationLevels) {
+		return locationLevels[start:], true
+	}
+	return locationLevels[start : end+1], false
+}
+
+// levelIndex returns the index of the level in locationLevels, or defaultIndex if unknown
+func levelIndex(level string, defaultIndex int) int {
+	for i, item := range locationLevels {
+		if item == level {
+			return i
+		}
+	}
+	return defaultIndex
+}
+
+// withoutNearbyRouter returns the router chain without the nearby router, the filter only router is kept at
+// the end so that the unhealthy and isolated instances are still filtered
+func withoutNearbyRouter(chain []string) []string {
+	routers := make([]string, 0, len(chain)+1)
+	for _, router := range chain {
+		if router != config.DefaultServiceRouterNearbyBased {
+			routers = append(routers, router)
+		}
+	}
+	if len(routers) == 0 {
+		// 空的路由链不会追加兜底的过滤路由
+		routers = append(routers, config.DefaultServiceRouterFilterOnly)
+	}
+	return routers
+}
+
+// filterByLocation keeps the instances nearest to the location, starting from the match level and degrading
+// to the wider level up to the max match level. All instances are returned if nothing matched and the policy
+// degrades to all, otherwise none is returned.
+func filterByLocation(instances []model.Instance, location *cidrLocation, policy nearbyPolicy) []model.Instance {
+	levels, degradeAll := policy.levels()
+	for _, level := range levels {
+		if !location.hasLevel(level) {
+			continue
+		}
+		matched := make([]model.Instance, 0, len(instances))
+		for _, ins := range instances {
+			if location.match(ins, level) {
+				matched = append(matched, ins)
+			}
+		}
+		if len(matched) > 0 {
+			return matched
+		}
+	}
+	if !degradeAll {
+		return nil
+	}
+	return instances
+}
+
+func (l *cidrLocation) hasLevel(level string) bool {
+	switch level {
+	case matchLevelCampus:
+		return len(l.Campus) > 0
+	case matchLevelZone:
+		return len(l.Zone) > 0
+	case matchLevelRegion:
+		return len(l.Region) > 0
+	}
+	return false
+}
+
+// match returns whether the instance is in the same location as l at the level and the wider levels
+func (l *cidrLocation) match(ins model.Instance, level string) bool {
+	switch level {
+	case matchLevelCampus:
+		if ins.GetCampus() != l.Campus {
+			return false
+		}
+		fallthrough
+	case matchLevelZone:
+		if len(l.Zone) > 0 && ins.GetZone() != l.Zone {
+			return false
+		}
+		fallthrough
+	case matchLevelRegion:
+		if len(l.Region) > 0 && ins.GetRegion() != l.Region {
+			return false
+		}
+	}
+	return true
+}
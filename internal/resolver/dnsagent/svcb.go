@@ -0,0 +1,78 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package dnsagent
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/polarismesh/polaris-go/pkg/model"
+)
+
+const (
+	// DefaultSvcbAlpnMetadataKey default instance metadata key of the alpn ids
+	DefaultSvcbAlpnMetadataKey = "alpn"
+)
+
+// svcbRecord synthesize the SVCB/HTTPS record of the instance, the target is the address name of the instance
+// like the SRV record, and the instance address is carried as the ip hint to save a lookup
+func (r *resolverDiscovery) svcbRecord(question dns.Question, address net.IP, ins model.Instance) dns.RR {
+	svcb := dns.SVCB{
+		Hdr: dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET,
+			Ttl: uint32(r.dnsTtl)},
+		// 优先级 0 表示别名模式，北极星实例优先级从 0 开始
+		Priority: uint16(math.Min(float64(ins.GetPriority())+1, math.MaxUint16)),
+		Target:   encodeIPAsFqdn(address, ins.GetInstanceKey().ServiceKey),
+	}
+	metadata := ins.GetMetadata()
+	if alpn := parseAlpn(metadata[r.config.SvcbAlpnMetadataKey]); len(alpn) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: alpn})
+	}
+	port := ins.GetPort()
+	if key := r.config.SvcbPortMetadataKey; len(key) > 0 {
+		if value, err := strconv.ParseUint(metadata[key], 10, 16); err == nil && value > 0 {
+			port = uint32(value)
+		}
+	}
+	if port > 0 && port <= math.MaxUint16 {
+		svcb.Value = append(svcb.Value, &dns.SVCBPort{Port: uint16(port)})
+	}
+	if ipv4 := address.To4(); ipv4 != nil {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: []net.IP{ipv4}})
+	} else if len(address) == net.IPv6len {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: []net.IP{address}})
+	}
+	if question.Qtype == dns.TypeHTTPS {
+		return &dns.HTTPS{SVCB: svcb}
+	}
+	return &svcb
+}
+
+// parseAlpn parse the comma separated alpn ids, such as "h2,http/1.1"
+func parseAlpn(value string) []string {
+	var alpn []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			alpn = append(alpn, item)
+		}
+	}
+	return alpn
+}
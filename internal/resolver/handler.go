@@ -7,17 +7,26 @@ import (
 
 	"github.com/miekg/dns"
 
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/acl"
 	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/limiter"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/qtype"
 	"github.com/polarismesh/polaris-sidecar/internal/resolver/recursor"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/rewrite"
 	"github.com/polarismesh/polaris-sidecar/pkg/constants"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
-func buildDnsHandler(protocol string, resolvers []common.NamingResolver, recurseProxy *recursor.Proxy) *dnsHandler {
+func buildDnsHandler(protocol string, resolvers []common.NamingResolver, recurseProxy *recursor.Proxy,
+	queryLimiter *limiter.Limiter, queryACL *acl.ACL, rewriter *rewrite.Rewriter, policy *qtype.Policy) *dnsHandler {
 	return &dnsHandler{
 		protocol:     protocol,
 		resolvers:    resolvers,
 		recurseProxy: recurseProxy,
+		limiter:      queryLimiter,
+		acl:          queryACL,
+		rewriter:     rewriter,
+		policy:       policy,
 	}
 }
 
@@ -25,6 +34,14 @@ type dnsHandler struct {
 	protocol     string
 	resolvers    []common.NamingResolver
 	recurseProxy *recursor.Proxy
+	// limiter limit the queries of each client, nil if disabled
+	limiter *limiter.Limiter
+	// acl check the clients and the query names, nil if disabled
+	acl *acl.ACL
+	// rewriter rewrite the query name before dispatching to the resolvers, nil if there is no rule
+	rewriter *rewrite.Rewriter
+	// policy decide how to answer the query types other than A/AAAA/SRV, nil means forwarding all of them
+	policy *qtype.Policy
 }
 
 // Preprocess removes the search suffix from the query name if it is present.
@@ -47,6 +64,16 @@ func (d *dnsHandler) Preprocess(qname string) string {
 	return qname
 }
 
+// rewrite apply the rewrite rules to the query name, the search suffix is removed if no rule matched
+func (d *dnsHandler) rewrite(qname string) (string, bool) {
+	if d.rewriter != nil {
+		if rewritten, matched := d.rewriter.Rewrite(qname); matched {
+			return rewritten, true
+		}
+	}
+	return d.Preprocess(qname), false
+}
+
 // ServeDNS handler callback
 func (d *dnsHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
 	defer func() {
@@ -60,20 +87,57 @@ func (d *dnsHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
 		common.WriteDnsCode(d.protocol, w, req, dns.RcodeRefused)
 		return
 	}
-	// questions type we only accept
+	if d.limiter != nil {
+		if !d.limiter.AllowQuery(common.AddrIP(w.RemoteAddr())) {
+			limiter.CountDropped(limiter.ReasonQueryRate, d.limiter.Action())
+			limiter.WriteLimited(d.protocol, w, req, d.limiter.Action())
+			return
+		}
+		if d.protocol == constants.UdpProtocol {
+			w = d.limiter.WrapWriter(w, req)
+		}
+	}
+	clientIP := common.AddrIP(w.RemoteAddr())
+	if d.acl != nil && !d.acl.AllowClient(clientIP) {
+		acl.CountDenied(acl.ReasonClient)
+		common.WriteDnsCode(d.protocol, w, req, dns.RcodeRefused)
+		return
+	}
 	question := req.Question[0]
-	if canDoResolve(question.Qtype) {
-		qname := d.Preprocess(question.Name)
-		log.Infof("[resolver] qname %s, raw question name：%s", qname, question.Name)
+	qname, rewritten := d.rewrite(question.Name)
+	// A/AAAA/SRV 总是由 resolver 解析，其他类型按策略合成、本地应答空记录或者转发
+	action := d.policy.Action(qname, question.Qtype)
+	if action != qtype.ActionForward {
+		log.Infof("[resolver] qname %s, raw question name：%s, action: %s", qname, question.Name, action)
+		if d.acl != nil && !d.acl.AllowQuery(clientIP, qname) {
+			log.Infof("[resolver] qname %s is denied for client %s", qname, clientIP)
+			acl.CountDenied(acl.ReasonName)
+			common.WriteDnsCode(d.protocol, w, req, dns.RcodeRefused)
+			return
+		}
+		if action == qtype.ActionNodata {
+			common.WriteDnsResponse(d.protocol, w, req, &dns.Msg{})
+			return
+		}
 		ctx := context.WithValue(context.Background(), constants.ContextProtocol, d.protocol)
+		ctx = context.WithValue(ctx, constants.ContextRequest, req)
+		ctx = context.WithValue(ctx, constants.ContextRemoteAddr, w.RemoteAddr())
 		for _, handler := range d.resolvers {
 			resp := handler.ServeDNS(ctx, question, qname)
 			if nil != resp {
+				if rewritten {
+					rewrite.RestoreNames(resp, qname, question.Name)
+				}
 				common.WriteDnsResponse(d.protocol, w, req, resp)
 				return
 			}
 		}
 	}
+	if d.acl != nil && !d.acl.AllowRecursion(clientIP) {
+		acl.CountDenied(acl.ReasonRecursion)
+		common.WriteDnsCode(d.protocol, w, req, dns.RcodeRefused)
+		return
+	}
 	// 降级到本地 nameserver
 	resp := d.recurseProxy.HandleDNS(d.protocol, w, req)
 	if nil != resp {
@@ -82,17 +146,3 @@ func (d *dnsHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
 	}
 	common.WriteDnsCode(d.protocol, w, req, dns.RcodeServerFailure)
 }
-
-func canDoResolve(qType uint16) bool {
-	if qType == dns.TypeA {
-		return true
-	}
-	if qType == dns.TypeAAAA {
-		return true
-	}
-	if qType == dns.TypeSRV {
-		return true
-	}
-
-	return false
-}
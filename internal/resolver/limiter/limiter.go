@@ -0,0 +1,233 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package limiter
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// ActionRefused answer REFUSED to the queries over the limit
+	ActionRefused = "refused"
+	// ActionTruncate answer an empty truncated reply to the queries over the limit,
+	// so that the legitimate clients retry over tcp
+	ActionTruncate = "truncate"
+
+	// DefaultMaxClients default max count of the clients to track
+	DefaultMaxClients = 65536
+)
+
+// Config the query rate limit config of the dns listener
+type Config struct {
+	Enable bool `yaml:"enable"`
+	// QPS the tokens refilled per second for each client ip
+	QPS float64 `yaml:"qps"`
+	// Burst the bucket size for each client ip
+	Burst int `yaml:"burst"`
+	// Action the reply to the queries over the limit, refused or truncate
+	Action string `yaml:"action"`
+	// MaxClients max count of the clients to track, the least recently used clients are evicted when exceeded
+	MaxClients int `yaml:"max_clients"`
+	// RRL response rate limiting
+	RRL *RRLConfig `yaml:"rrl"`
+}
+
+// RRLConfig response rate limiting config, identical responses sent to the same client subnet are limited
+type RRLConfig struct {
+	Enable bool `yaml:"enable"`
+	// ResponsesPerSecond identical responses allowed per second for each client subnet
+	ResponsesPerSecond float64 `yaml:"responses_per_second"`
+	// IPv4PrefixLength prefix length to group the ipv4 clients
+	IPv4PrefixLength int `yaml:"ipv4_prefix_length"`
+	// IPv6PrefixLength prefix length to group the ipv6 clients
+	IPv6PrefixLength int `yaml:"ipv6_prefix_length"`
+	// Slip every n-th limited response is sent as a truncated reply instead of being dropped, 0 drops all
+	Slip int `yaml:"slip"`
+}
+
+// IsValidPrefixLength check whether the rrl prefix lengths are in range, 0 means the default
+func (c *RRLConfig) IsValidPrefixLength() bool {
+	return c.IPv4PrefixLength >= 0 && c.IPv4PrefixLength <= 8*net.IPv4len &&
+		c.IPv6PrefixLength >= 0 && c.IPv6PrefixLength <= 8*net.IPv6len
+}
+
+// IsValidAction check whether the action is supported, empty means refused
+func IsValidAction(action string) bool {
+	return action == "" || action == ActionRefused || action == ActionTruncate
+}
+
+// tokenBucket the classic token bucket, it is not thread safe
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refill the tokens since the last call and take one token
+func (b *tokenBucket) take(now time.Time, rate float64, burst float64) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// bucketSet token buckets keyed by the client, the least recently used bucket is evicted when full
+type bucketSet struct {
+	mutex      sync.Mutex
+	rate       float64
+	burst      float64
+	maxBuckets int
+	buckets    map[string]*list.Element
+	// lru the buckets ordered by the last use, the front is the most recent one
+	lru *list.List
+}
+
+// keyedBucket the element of the lru list
+type keyedBucket struct {
+	key    string
+	bucket tokenBucket
+}
+
+func newBucketSet(rate float64, burst float64, maxBuckets int) *bucketSet {
+	return &bucketSet{
+		rate:       rate,
+		burst:      burst,
+		maxBuckets: maxBuckets,
+		buckets:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+func (s *bucketSet) allow(key string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	elem, ok := s.buckets[key]
+	if ok {
+		s.lru.MoveToFront(elem)
+	} else {
+		if s.lru.Len() >= s.maxBuckets {
+			// 淘汰最久未使用的客户端，新的客户端始终受限流约束
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.buckets, oldest.Value.(*keyedBucket).key)
+		}
+		elem = s.lru.PushFront(&keyedBucket{key: key, bucket: tokenBucket{tokens: s.burst, last: now}})
+		s.buckets[key] = elem
+	}
+	return elem.Value.(*keyedBucket).bucket.take(now, s.rate, s.burst)
+}
+
+// Limiter limit the queries of each client ip, and the identical responses of each client subnet
+type Limiter struct {
+	config  *Config
+	queries *bucketSet
+	rrl     *bucketSet
+	// slips count of the limited responses of each rrl key, used to decide the slip
+	slipMutex sync.Mutex
+	slips     map[string]int
+	now       func() time.Time
+}
+
+// New build the limiter, it returns nil if the config is not enabled
+func New(config *Config) *Limiter {
+	if config == nil || !config.Enable {
+		return nil
+	}
+	if len(config.Action) == 0 {
+		config.Action = ActionRefused
+	}
+	if config.MaxClients <= 0 {
+		config.MaxClients = DefaultMaxClients
+	}
+	if config.Burst <= 0 {
+		// 速率小于 1 时桶容量至少为 1，否则永远无法放行
+		config.Burst = int(math.Ceil(config.QPS))
+	}
+	l := &Limiter{config: config, now: time.Now}
+	if config.QPS > 0 {
+		l.queries = newBucketSet(config.QPS, float64(config.Burst), config.MaxClients)
+	}
+	if config.RRL != nil && config.RRL.Enable && config.RRL.ResponsesPerSecond > 0 {
+		if config.RRL.IPv4PrefixLength <= 0 {
+			config.RRL.IPv4PrefixLength = 24
+		}
+		if config.RRL.IPv6PrefixLength <= 0 {
+			config.RRL.IPv6PrefixLength = 56
+		}
+		l.rrl = newBucketSet(config.RRL.ResponsesPerSecond, math.Max(config.RRL.ResponsesPerSecond, 1),
+			config.MaxClients)
+		l.slips = map[string]int{}
+	}
+	return l
+}
+
+// Action returns the reply to the queries over the limit
+func (l *Limiter) Action() string {
+	return l.config.Action
+}
+
+// AllowQuery check whether the query from the client ip is under the limit
+func (l *Limiter) AllowQuery(ip net.IP) bool {
+	if l.queries == nil || ip == nil {
+		return true
+	}
+	return l.queries.allow(ip.String(), l.now())
+}
+
+// RRLEnabled returns whether the response rate limiting is enabled
+func (l *Limiter) RRLEnabled() bool {
+	return l.rrl != nil
+}
+
+// AllowResponse check whether the identical response to the client subnet is under the limit,
+// slip is true if the limited response should be sent as a truncated reply
+func (l *Limiter) AllowResponse(ip net.IP, qname string, qtype uint16, rcode int) (bool, bool) {
+	if l.rrl == nil || ip == nil {
+		return true, false
+	}
+	key := rrlKey(l.subnet(ip), qname, qtype, rcode)
+	if l.rrl.allow(key, l.now()) {
+		return true, false
+	}
+	if l.config.RRL.Slip <= 0 {
+		return false, false
+	}
+	l.slipMutex.Lock()
+	defer l.slipMutex.Unlock()
+	if len(l.slips) >= l.config.MaxClients {
+		l.slips = map[string]int{}
+	}
+	l.slips[key]++
+	return false, l.slips[key]%l.config.RRL.Slip == 0
+}
+
+func (l *Limiter) subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(l.config.RRL.IPv4PrefixLength, 8*net.IPv4len)).String()
+	}
+	return ip.Mask(net.CIDRMask(l.config.RRL.IPv6PrefixLength, 8*net.IPv6len)).String()
+}
@@ -0,0 +1,99 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package limiter
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_AllowQuery(t *testing.T) {
+	assert.Nil(t, New(&Config{}))
+	l := New(&Config{Enable: true, QPS: 1, Burst: 2, MaxClients: 1})
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	client := net.ParseIP("10.0.0.1")
+
+	assert.True(t, l.AllowQuery(client))
+	assert.True(t, l.AllowQuery(client))
+	assert.False(t, l.AllowQuery(client))
+	// 令牌按速率补充
+	now = now.Add(time.Second)
+	assert.True(t, l.AllowQuery(client))
+	assert.False(t, l.AllowQuery(client))
+
+	// 超过跟踪上限时淘汰最久未使用的客户端，新的客户端仍然受限
+	other := net.ParseIP("10.0.0.2")
+	assert.True(t, l.AllowQuery(other))
+	assert.True(t, l.AllowQuery(other))
+	assert.False(t, l.AllowQuery(other))
+	assert.NotContains(t, l.queries.buckets, client.String())
+	assert.Equal(t, 1, l.queries.lru.Len())
+}
+
+func TestLimiter_AllowResponse(t *testing.T) {
+	l := New(&Config{Enable: true, RRL: &RRLConfig{Enable: true, ResponsesPerSecond: 1, Slip: 2}})
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	allow, _ := l.AllowResponse(net.ParseIP("10.0.0.1"), "foo.default.", dns.TypeA, dns.RcodeSuccess)
+	assert.True(t, allow)
+	// 同一子网的相同应答被限制，每 2 个被限制的应答发送一次截断应答
+	allow, slip := l.AllowResponse(net.ParseIP("10.0.0.2"), "FOO.default.", dns.TypeA, dns.RcodeSuccess)
+	assert.False(t, allow)
+	assert.False(t, slip)
+	allow, slip = l.AllowResponse(net.ParseIP("10.0.0.3"), "foo.default.", dns.TypeA, dns.RcodeSuccess)
+	assert.False(t, allow)
+	assert.True(t, slip)
+	// 不同的应答或不同的子网不受影响
+	allow, _ = l.AllowResponse(net.ParseIP("10.0.0.1"), "foo.default.", dns.TypeAAAA, dns.RcodeSuccess)
+	assert.True(t, allow)
+	allow, _ = l.AllowResponse(net.ParseIP("10.0.1.1"), "foo.default.", dns.TypeA, dns.RcodeSuccess)
+	assert.True(t, allow)
+}
+
+func TestLimiter_FractionalRate(t *testing.T) {
+	l := New(&Config{Enable: true, QPS: 0.5, RRL: &RRLConfig{Enable: true, ResponsesPerSecond: 0.5}})
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	// 速率小于 1 时仍然放行第一个请求，之后每 2 秒放行一个
+	client := net.ParseIP("10.0.0.1")
+	assert.True(t, l.AllowQuery(client))
+	assert.False(t, l.AllowQuery(client))
+	allow, _ := l.AllowResponse(client, "foo.default.", dns.TypeA, dns.RcodeSuccess)
+	assert.True(t, allow)
+	allow, _ = l.AllowResponse(client, "foo.default.", dns.TypeA, dns.RcodeSuccess)
+	assert.False(t, allow)
+	now = now.Add(2 * time.Second)
+	assert.True(t, l.AllowQuery(client))
+	allow, _ = l.AllowResponse(client, "foo.default.", dns.TypeA, dns.RcodeSuccess)
+	assert.True(t, allow)
+}
+
+func TestRRLConfig_IsValidPrefixLength(t *testing.T) {
+	assert.True(t, (&RRLConfig{}).IsValidPrefixLength())
+	assert.True(t, (&RRLConfig{IPv4PrefixLength: 32, IPv6PrefixLength: 128}).IsValidPrefixLength())
+	assert.False(t, (&RRLConfig{IPv4PrefixLength: 33}).IsValidPrefixLength())
+	assert.False(t, (&RRLConfig{IPv6PrefixLength: 129}).IsValidPrefixLength())
+	assert.False(t, (&RRLConfig{IPv4PrefixLength: -1}).IsValidPrefixLength())
+}
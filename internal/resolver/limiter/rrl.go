@@ -0,0 +1,107 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package limiter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/pkg/constants"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	// ReasonQueryRate the query is over the per client rate limit
+	ReasonQueryRate = "query_rate"
+	// ReasonResponseRate the response is over the response rate limit
+	ReasonResponseRate = "response_rate"
+
+	actionDrop = "drop"
+)
+
+var droppedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "polaris_sidecar_dns_dropped_total",
+	Help: "Count of the dns queries limited by the sidecar",
+}, []string{"reason", "action"})
+
+func init() {
+	prometheus.MustRegister(droppedCounter)
+}
+
+// CountDropped count the limited query in metrics
+func CountDropped(reason string, action string) {
+	droppedCounter.WithLabelValues(reason, action).Inc()
+}
+
+func rrlKey(subnet string, qname string, qtype uint16, rcode int) string {
+	return subnet + "/" + strings.ToLower(qname) + "/" + strconv.Itoa(int(qtype)) + "/" + strconv.Itoa(rcode)
+}
+
+// WriteLimited answer the query over the limit with the action
+func WriteLimited(protocol string, w dns.ResponseWriter, req *dns.Msg, action string) {
+	if action == ActionTruncate && protocol == constants.UdpProtocol {
+		writeTruncated(w, req)
+		return
+	}
+	common.WriteDnsCode(protocol, w, req, dns.RcodeRefused)
+}
+
+func writeTruncated(w dns.ResponseWriter, req *dns.Msg) {
+	msg := &dns.Msg{}
+	msg.SetReply(req)
+	msg.Truncated = true
+	if err := w.WriteMsg(msg); err != nil {
+		log.Errorf("[resolver] fail to write truncated dns response message, err: %v", err)
+	}
+}
+
+// rrlWriter apply the response rate limiting before writing the response
+type rrlWriter struct {
+	dns.ResponseWriter
+	limiter *Limiter
+	req     *dns.Msg
+}
+
+// WrapWriter wrap the writer to apply the response rate limiting, it returns the raw writer if rrl is disabled
+func (l *Limiter) WrapWriter(w dns.ResponseWriter, req *dns.Msg) dns.ResponseWriter {
+	if l == nil || !l.RRLEnabled() || len(req.Question) == 0 {
+		return w
+	}
+	return &rrlWriter{ResponseWriter: w, limiter: l, req: req}
+}
+
+// WriteMsg drop the response or send a truncated reply if the identical responses are over the limit
+func (w *rrlWriter) WriteMsg(msg *dns.Msg) error {
+	question := w.req.Question[0]
+	allow, slip := w.limiter.AllowResponse(common.AddrIP(w.RemoteAddr()), question.Name, question.Qtype,
+		msg.Rcode)
+	if allow {
+		return w.ResponseWriter.WriteMsg(msg)
+	}
+	if slip {
+		CountDropped(ReasonResponseRate, ActionTruncate)
+		writeTruncated(w.ResponseWriter, w.req)
+		return nil
+	}
+	CountDropped(ReasonResponseRate, actionDrop)
+	return nil
+}
@@ -20,27 +20,68 @@ package meshproxy
 import (
 	"encoding/json"
 	"fmt"
+	"time"
+)
+
+const (
+	// AllNamespaces the wildcard to load the services of all the namespaces
+	AllNamespaces = "*"
+	// AllProtocols the wildcard of the srv default ports which applies to all the protocols
+	AllProtocols = "*"
 )
 
 type resolverConfig struct {
-	Namespace          string `json:"namespace"`
-	ReloadIntervalSec  int    `json:"reload_interval_sec"`
-	DNSAnswerIp        string `json:"dns_answer_ip"`
-	FilterByBusiness   string `json:"filter_by_business"`
-	RecursionAvailable bool   `json:"recursion_available"`
+	Namespace         string `json:"namespace"`
+	ReloadIntervalSec int    `json:"reload_interval_sec"`
+	// DNSAnswerIp comma separated ips to answer, both ipv4 and ipv6 are supported
+	DNSAnswerIp string `json:"dns_answer_ip"`
+	// VIPCidr allocate a stable virtual ip for each service from the cidr instead of answering dns_answer_ip
+	VIPCidr string `json:"vip_cidr"`
+	// VIPCidrV6 allocate a stable virtual ipv6 for each service from the cidr to answer AAAA queries
+	VIPCidrV6 string `json:"vip_cidr_v6"`
+	// VIPStorePath file to persist the vip allocation table across restarts
+	VIPStorePath string `json:"vip_store_path"`
+	// VIPReleaseDelaySec seconds to keep the vip of a service missing from the service list before releasing it
+	VIPReleaseDelaySec int `json:"vip_release_delay_sec"`
+	// Namespaces the namespaces to load the services from, "*" loads all the namespaces,
+	// "current" refers to the namespace of the sidecar
+	Namespaces []string `json:"namespaces"`
+	// FilterByBusiness only load the services belong to the business
+	FilterByBusiness string `json:"filter_by_business"`
+	// ServiceSelector only load the services whose metadata contains all the key values, the services are
+	// filtered by polaris so that the instances are not loaded
+	ServiceSelector    map[string]string `json:"service_selector"`
+	RecursionAvailable bool              `json:"recursion_available"`
+	// SRVDefaultPorts the port answered in the srv records of each protocol, the protocol is taken from the
+	// query name in the format of _<protocol>._tcp.<service>.<namespace>
+	SRVDefaultPorts map[string]int `json:"srv_default_ports"`
+	// SRVPortMetadataKey the key of the service metadata which specifies the port answered in the srv records,
+	// the metadata is looked up on the first SRV query of the service and cached for the reload interval
+	SRVPortMetadataKey string `json:"srv_port_metadata_key"`
+	// SnapshotPath file to persist the lookup table, it is loaded on startup so that the services can be
+	// resolved before the service list is loaded from polaris, empty means disabled
+	SnapshotPath string `json:"snapshot_path"`
 }
 
 func parseOptions(options map[string]interface{}) (*resolverConfig, error) {
 	config := &resolverConfig{}
-	if len(options) == 0 {
-		return config, nil
+	if len(options) > 0 {
+		jsonBytes, err := json.Marshal(options)
+		if nil != err {
+			return nil, fmt.Errorf("fail to marshal %s config entry, err is %v", name, err)
+		}
+		if err = json.Unmarshal(jsonBytes, config); nil != err {
+			return nil, fmt.Errorf("fail to unmarshal %s config entry, err is %v", name, err)
+		}
+	}
+	if len(config.Namespaces) == 0 {
+		config.Namespaces = []string{AllNamespaces}
 	}
-	jsonBytes, err := json.Marshal(options)
-	if nil != err {
-		return nil, fmt.Errorf("fail to marshal %s config entry, err is %v", name, err)
+	if len(config.VIPStorePath) == 0 {
+		config.VIPStorePath = DefaultVIPStorePath
 	}
-	if err = json.Unmarshal(jsonBytes, config); nil != err {
-		return nil, fmt.Errorf("fail to unmarshal %s config entry, err is %v", name, err)
+	if config.VIPReleaseDelaySec <= 0 {
+		config.VIPReleaseDelaySec = int(DefaultVIPReleaseDelay / time.Second)
 	}
 	return config, nil
 }
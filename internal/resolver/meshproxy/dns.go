@@ -21,39 +21,96 @@ import (
 	"context"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 
 	"github.com/miekg/dns"
 
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
 	"github.com/polarismesh/polaris-sidecar/pkg/constants"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
 type LocalDNSServer struct {
 	// dns look up table
-	lookupTable        atomic.Value
+	lookupTable atomic.Value
+	// updateMutex serialize the writers of the lookup table, readers are lock free
+	updateMutex        sync.Mutex
 	dnsTtl             uint32
 	recursionAvailable bool
+	answerOrder        string
+	// srvPorts the default srv port of each protocol, "*" applies to all the protocols
+	srvPorts map[string]int
+	// servicePort looks up the mesh port of the host on the SRV and SVCB queries, nil means not specified
+	servicePort func(hostname string) (int, bool)
 }
 
-func (h *LocalDNSServer) UpdateLookupTable(polarisServices map[string]struct{}, dnsResponseIp string) {
-	lookupTable := &LookupTable{
-		allHosts: map[string]struct{}{},
-		name4:    map[string][]net.IP{},
-		name6:    map[string][]net.IP{},
-		dnsTtl:   h.dnsTtl,
-	}
+// serviceAnswer the answers of a service
+type serviceAnswer struct {
+	ipv4 []net.IP
+	ipv6 []net.IP
+}
+
+// UpdateLookupTable rebuild the lookup table, resolve returns the answers of the service
+func (h *LocalDNSServer) UpdateLookupTable(polarisServices map[string]struct{},
+	resolve func(service string) *serviceAnswer) {
+	h.updateMutex.Lock()
+	defer h.updateMutex.Unlock()
+	lookupTable := newLookupTable(h.dnsTtl)
 
 	var altHosts map[string]struct{}
 	for service := range polarisServices {
 		altHosts = map[string]struct{}{service + ".": {}}
-		lookupTable.buildDNSAnswers(altHosts, []net.IP{net.ParseIP(dnsResponseIp)}, nil)
+		lookupTable.buildDNSAnswers(altHosts, resolve(service))
 	}
 	h.lookupTable.Store(lookupTable)
 	log.Infof("[mesh] updated lookup table with %d hosts, allHosts are %v",
 		len(lookupTable.allHosts), lookupTable.allHosts)
 }
 
+// ApplyServiceChanges update the lookup table incrementally, only the added and removed services are touched,
+// the table is copied on write so that the running queries are not affected
+func (h *LocalDNSServer) ApplyServiceChanges(added map[string]struct{}, removed map[string]struct{},
+	resolve func(service string) *serviceAnswer) {
+	h.updateMutex.Lock()
+	defer h.updateMutex.Unlock()
+	lookupTable := newLookupTable(h.dnsTtl)
+	if lp := h.lookupTable.Load(); lp != nil {
+		lookupTable = lp.(*LookupTable).clone()
+	}
+	for service := range removed {
+		lookupTable.removeHost(service + ".")
+	}
+	for service := range added {
+		lookupTable.buildDNSAnswers(map[string]struct{}{service + ".": {}}, resolve(service))
+	}
+	h.lookupTable.Store(lookupTable)
+	log.Infof("[mesh] updated lookup table with %d hosts, added %d, removed %d",
+		len(lookupTable.allHosts), len(added), len(removed))
+}
+
+// parseAnswerIPs parse the comma separated answer ips into ipv4 and ipv6 addresses
+func parseAnswerIPs(dnsResponseIp string) ([]net.IP, []net.IP) {
+	var ipv4, ipv6 []net.IP
+	for _, value := range strings.Split(dnsResponseIp, constants.CommaSymbol) {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			log.Errorf("[mesh] invalid dns answer ip %s", value)
+			continue
+		}
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, ip.To4())
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	return ipv4, ipv6
+}
+
 type LookupTable struct {
 	// This table will be first looked up to see if the host is something that we got a Nametable entry for
 	// (i.e. came from istiod's service registry). If it is, then we will be able to confidently return
@@ -71,15 +128,51 @@ type LookupTable struct {
 	dnsTtl uint32
 }
 
-func (table *LookupTable) buildDNSAnswers(altHosts map[string]struct{}, ipv4 []net.IP, ipv6 []net.IP) {
+func newLookupTable(dnsTtl uint32) *LookupTable {
+	return &LookupTable{
+		allHosts: map[string]struct{}{},
+		name4:    map[string][]net.IP{},
+		name6:    map[string][]net.IP{},
+		dnsTtl:   dnsTtl,
+	}
+}
+
+// clone returns a shallow copy of the table, the ip slices are shared since they are never modified in place
+func (table *LookupTable) clone() *LookupTable {
+	copied := &LookupTable{
+		allHosts: make(map[string]struct{}, len(table.allHosts)),
+		name4:    make(map[string][]net.IP, len(table.name4)),
+		name6:    make(map[string][]net.IP, len(table.name6)),
+		dnsTtl:   table.dnsTtl,
+	}
+	for h := range table.allHosts {
+		copied.allHosts[h] = struct{}{}
+	}
+	for h, ips := range table.name4 {
+		copied.name4[h] = ips
+	}
+	for h, ips := range table.name6 {
+		copied.name6[h] = ips
+	}
+	return copied
+}
+
+func (table *LookupTable) removeHost(host string) {
+	host = strings.ToLower(host)
+	delete(table.allHosts, host)
+	delete(table.name4, host)
+	delete(table.name6, host)
+}
+
+func (table *LookupTable) buildDNSAnswers(altHosts map[string]struct{}, answer *serviceAnswer) {
 	for h := range altHosts {
 		h = strings.ToLower(h)
 		table.allHosts[h] = struct{}{}
-		if len(ipv4) > 0 {
-			table.name4[h] = ipv4
+		if len(answer.ipv4) > 0 {
+			table.name4[h] = answer.ipv4
 		}
-		if len(ipv6) > 0 {
-			table.name6[h] = ipv6
+		if len(answer.ipv6) > 0 {
+			table.name6[h] = answer.ipv6
 		}
 	}
 }
@@ -114,10 +207,90 @@ func (table *LookupTable) lookupHost(qtype uint16, questionHost string, hostname
 	return out, hostFound
 }
 
-func newLocalDNSServer(dnsTtl uint32, recursionAvailable bool) (*LocalDNSServer, error) {
+// lookupPort returns the mesh port of the host specified by the service metadata, nil servicePort means
+// not specified
+func lookupPort(hostname string, servicePort func(string) (int, bool)) (int, bool) {
+	if servicePort == nil {
+		return 0, false
+	}
+	return servicePort(hostname)
+}
+
+// lookupSRV returns the srv record pointing to the host itself, and the address records of the host as extra,
+// the port is taken from the service metadata, or the default port of the protocol
+func (table *LookupTable) lookupSRV(questionHost string, hostname string, protocol string,
+	srvPorts map[string]int, servicePort func(string) (int, bool)) ([]dns.RR, []dns.RR, bool) {
+	if _, hostFound := table.allHosts[hostname]; !hostFound {
+		return nil, nil, false
+	}
+	port, ok := lookupPort(hostname, servicePort)
+	if !ok {
+		if port, ok = srvPorts[protocol]; !ok {
+			port = srvPorts[AllProtocols]
+		}
+	}
+	if port <= 0 {
+		return nil, nil, true
+	}
+	srv := &dns.SRV{
+		Hdr:    dns.RR_Header{Name: questionHost, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: table.dnsTtl},
+		Weight: 1,
+		Port:   uint16(port),
+		Target: hostname,
+	}
+	extra := append(a(hostname, table.name4[hostname], table.dnsTtl), aaaa(hostname, table.name6[hostname],
+		table.dnsTtl)...)
+	return []dns.RR{srv}, extra, true
+}
+
+// lookupSVCB returns the SVCB/HTTPS record in service mode pointing to the host itself, the port is taken as
+// the SRV record of all the protocols, and the addresses of the host are carried as the ip hints
+func (table *LookupTable) lookupSVCB(qtype uint16, questionHost string, hostname string,
+	srvPorts map[string]int, servicePort func(string) (int, bool)) ([]dns.RR, bool) {
+	if _, hostFound := table.allHosts[hostname]; !hostFound {
+		return nil, false
+	}
+	svcb := dns.SVCB{
+		Hdr:      dns.RR_Header{Name: questionHost, Rrtype: qtype, Class: dns.ClassINET, Ttl: table.dnsTtl},
+		Priority: 1,
+		Target:   constants.DotSymbol,
+	}
+	port, ok := lookupPort(hostname, servicePort)
+	if !ok {
+		port = srvPorts[AllProtocols]
+	}
+	if port > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBPort{Port: uint16(port)})
+	}
+	if ips := table.name4[hostname]; len(ips) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: ips})
+	}
+	if ips := table.name6[hostname]; len(ips) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: ips})
+	}
+	if qtype == dns.TypeHTTPS {
+		return []dns.RR{&dns.HTTPS{SVCB: svcb}}, true
+	}
+	return []dns.RR{&svcb}, true
+}
+
+// splitSRVName split the srv query name in the format of _<protocol>._<transport>.<host> into protocol and host,
+// the query name without the prefix is treated as the host
+func splitSRVName(qname string) (string, string) {
+	labels := dns.SplitDomainName(qname)
+	if len(labels) > 2 && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_") {
+		return strings.TrimPrefix(labels[0], "_"), dns.Fqdn(strings.Join(labels[2:], "."))
+	}
+	return "", qname
+}
+
+func newLocalDNSServer(dnsTtl uint32, recursionAvailable bool, answerOrder string,
+	srvPorts map[string]int) (*LocalDNSServer, error) {
 	h := &LocalDNSServer{
 		dnsTtl:             dnsTtl,
 		recursionAvailable: recursionAvailable,
+		answerOrder:        answerOrder,
+		srvPorts:           srvPorts,
 	}
 	return h, nil
 }
@@ -130,12 +303,40 @@ func (h *LocalDNSServer) ServeDNS(ctx context.Context, question *dns.Question, q
 	}
 
 	lookupTable := lp.(*LookupTable)
+	if question.Qtype == dns.TypeSRV {
+		protocol, hostname := splitSRVName(strings.ToLower(qname))
+		answers, extra, hostFound := lookupTable.lookupSRV(question.Name, hostname, protocol, h.srvPorts,
+			h.servicePort)
+		if !hostFound {
+			log.Errorf("[mesh] DNS SRV lookup for %s not found, srv protocol:%s", qname, protocol)
+			return nil
+		}
+		response := new(dns.Msg)
+		response.Answer = answers
+		response.Extra = extra
+		response.Rcode = dns.RcodeSuccess
+		log.Infof("[mesh] DNS SRV lookup for %s found %d answers, srv protocol:%s", qname, len(answers), protocol)
+		return response
+	}
+	if question.Qtype == dns.TypeSVCB || question.Qtype == dns.TypeHTTPS {
+		answers, hostFound := lookupTable.lookupSVCB(question.Qtype, question.Name, strings.ToLower(qname),
+			h.srvPorts, h.servicePort)
+		if !hostFound {
+			return nil
+		}
+		response := new(dns.Msg)
+		response.Answer = answers
+		response.Rcode = dns.RcodeSuccess
+		log.Infof("[mesh] DNS %s lookup for %s found", dns.TypeToString[question.Qtype], qname)
+		return response
+	}
 	var answers []dns.RR
 
 	hostname := strings.ToLower(qname)
 	answers, hostFound := lookupTable.lookupHost(question.Qtype, question.Name, hostname)
 
 	if hostFound {
+		common.OrderAnswers(h.answerOrder, answers, nil, common.SourceIPFromContext(ctx))
 		response := new(dns.Msg)
 		response.Answer = answers
 		response.Rcode = dns.RcodeSuccess
@@ -19,6 +19,14 @@ package meshproxy
 
 import (
 	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -26,6 +34,7 @@ import (
 
 	debughttp "github.com/polarismesh/polaris-sidecar/internal/debugger"
 	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/pkg/constants"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 	polarisApi "github.com/polarismesh/polaris-sidecar/pkg/polaris"
 	"github.com/polarismesh/polaris-sidecar/pkg/utils"
@@ -35,10 +44,28 @@ const name = common.PluginNameMeshProxy
 
 type resolverMesh struct {
 	localDNSServer *LocalDNSServer
+	vipAllocator   *vipAllocator
 	config         *resolverConfig
 	registry       registry
 	suffix         string
 	consumer       polaris.ConsumerAPI
+	answerIPv4     []net.IP
+	answerIPv6     []net.IP
+	// servicesMutex serialize the updates from the watcher and the periodic reload
+	servicesMutex sync.Mutex
+	services      map[string]struct{}
+	// hostServices the service of each host in the lookup table, the host is lower cased
+	hostServices atomic.Value
+	portMutex    sync.Mutex
+	// ports the srv ports looked up from the service metadata by host
+	ports       map[string]*cachedPort
+	cancelWatch func()
+}
+
+// cachedPort the srv port of the service, 0 means not specified
+type cachedPort struct {
+	port   int
+	expire time.Time
 }
 
 func init() {
@@ -67,15 +94,34 @@ func (r *resolverMesh) Initialize(c *common.ConfigEntry) error {
 		return err
 	}
 	r.suffix = c.Suffix
-	r.localDNSServer, err = newLocalDNSServer(uint32(c.DnsTtl), r.config.RecursionAvailable)
+	r.answerIPv4, r.answerIPv6 = parseAnswerIPs(r.config.DNSAnswerIp)
+	if len(r.config.VIPCidr) > 0 || len(r.config.VIPCidrV6) > 0 {
+		r.vipAllocator, err = newVIPAllocator(r.config.VIPCidr, r.config.VIPCidrV6, r.config.VIPStorePath,
+			time.Duration(r.config.VIPReleaseDelaySec)*time.Second)
+		if nil != err {
+			return err
+		}
+	}
+	r.localDNSServer, err = newLocalDNSServer(uint32(c.DnsTtl), r.config.RecursionAvailable, c.AnswerOrder,
+		r.config.SRVDefaultPorts)
 	if nil != err {
 		return err
 	}
+	if len(r.config.SRVPortMetadataKey) > 0 {
+		r.ports = map[string]*cachedPort{}
+		r.localDNSServer.servicePort = r.srvPort
+	}
+	if len(r.config.SnapshotPath) > 0 {
+		r.localDNSServer.RestoreSnapshot(r.config.SnapshotPath)
+	}
 	return err
 }
 
 // Destroy will destroy the resolver on shutdown
 func (r *resolverMesh) Destroy() {
+	if nil != r.cancelWatch {
+		r.cancelWatch()
+	}
 	if nil != r.consumer {
 		r.consumer.Destroy()
 		log.Infof("[mesh] %s resolver polaris consumerAPI destroyed", name)
@@ -113,27 +159,25 @@ func (r *resolverMesh) ServeDNS(ctx context.Context, question dns.Question, qnam
 	return ret
 }
 
+// Start subscribe the service list changes, and reload the full list periodically as a consistency check
 func (r *resolverMesh) Start(ctx context.Context) {
 	interval := time.Duration(r.config.ReloadIntervalSec) * time.Second
 
+	cancelWatch, err := r.registry.WatchServices(r.applyServices)
+	if nil != err {
+		log.Errorf("[mesh] fail to watch services, fallback to reload every %v, err: %v", interval, err)
+	}
+	r.cancelWatch = cancelWatch
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
-		var currentServices map[string]struct{}
-		var nextServices map[string]struct{}
-		var changed bool
-
-		nextServices, changed = r.doReload(currentServices)
-		if changed {
-			currentServices = nextServices
+		if nil == cancelWatch {
+			r.doReload()
 		}
 		for {
 			select {
 			case <-ticker.C:
-				nextServices, changed = r.doReload(currentServices)
-				if changed {
-					currentServices = nextServices
-				}
+				r.doReload()
 			case <-ctx.Done():
 				return
 			}
@@ -143,20 +187,148 @@ func (r *resolverMesh) Start(ctx context.Context) {
 }
 
 func (r *resolverMesh) Debugger() []debughttp.DebugHandler {
-	return []debughttp.DebugHandler{}
+	return []debughttp.DebugHandler{
+		{
+			Path:    "/debug/mesh/vips",
+			Handler: r.handleVIPs,
+		},
+	}
 }
 
-func (r *resolverMesh) doReload(currentServices map[string]struct{}) (map[string]struct{}, bool) {
+// handleVIPs output the vip allocation table, so that the data plane can build the listener for each vip
+func (r *resolverMesh) handleVIPs(resp http.ResponseWriter, _ *http.Request) {
+	var vips []*VirtualIP
+	if r.vipAllocator != nil {
+		vips = r.vipAllocator.List()
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(vips); err != nil {
+		log.Errorf("[mesh] fail to write vip table, err: %v", err)
+	}
+}
+
+// resolve returns the answer ips of the service
+func (r *resolverMesh) resolve(service string) *serviceAnswer {
+	answer := &serviceAnswer{ipv4: r.answerIPv4, ipv6: r.answerIPv6}
+	if r.vipAllocator != nil {
+		answer.ipv4, answer.ipv6 = r.vipAllocator.Lookup(service)
+	}
+	return answer
+}
+
+// srvPort returns the srv port specified by the service metadata. The metadata is looked up on the first
+// SRV query of the host instead of on each rebuild, and cached for the reload interval.
+func (r *resolverMesh) srvPort(hostname string) (int, bool) {
+	now := time.Now()
+	r.portMutex.Lock()
+	cached, ok := r.ports[hostname]
+	r.portMutex.Unlock()
+	if ok && now.Before(cached.expire) {
+		return cached.port, cached.port > 0
+	}
+	hostServices, _ := r.hostServices.Load().(map[string]string)
+	service, ok := hostServices[hostname]
+	if !ok {
+		return 0, false
+	}
+	port := r.metadataPort(service)
+	interval := time.Duration(r.config.ReloadIntervalSec) * time.Second
+	if interval <= 0 {
+		interval = constants.MeshDefaultReloadIntervalSec * time.Second
+	}
+	r.portMutex.Lock()
+	r.ports[hostname] = &cachedPort{port: port, expire: now.Add(interval)}
+	r.portMutex.Unlock()
+	return port, port > 0
+}
+
+func (r *resolverMesh) metadataPort(service string) int {
+	metadata, err := r.registry.GetServiceMetadata(service)
+	if nil != err {
+		return 0
+	}
+	value, ok := metadata[r.config.SRVPortMetadataKey]
+	if !ok {
+		return 0
+	}
+	port, err := strconv.Atoi(value)
+	if nil != err || port <= 0 || port > math.MaxUint16 {
+		log.Errorf("[mesh] invalid srv port %q in metadata of service %s", value, service)
+		return 0
+	}
+	return port
+}
+
+// updateHostServices index the services by host, and drop the cached srv ports of the removed hosts
+func (r *resolverMesh) updateHostServices(services map[string]struct{}) {
+	hostServices := make(map[string]string, len(services))
+	for service := range services {
+		hostServices[strings.ToLower(service)+"."] = service
+	}
+	r.hostServices.Store(hostServices)
+	if r.ports == nil {
+		return
+	}
+	r.portMutex.Lock()
+	defer r.portMutex.Unlock()
+	for host := range r.ports {
+		if _, ok := hostServices[host]; !ok {
+			delete(r.ports, host)
+		}
+	}
+}
+
+func (r *resolverMesh) doReload() {
 	services, err := r.registry.GetCurrentNsService()
 	if err != nil {
 		log.Errorf("[mesh] error to get services, err: %v", err)
-		return nil, false
+		return
+	}
+	r.applyServices(services)
+}
+
+// applyServices update the vips and the lookup table with the difference between the current and the new list
+func (r *resolverMesh) applyServices(services map[string]struct{}) {
+	r.servicesMutex.Lock()
+	defer r.servicesMutex.Unlock()
+	if r.vipAllocator != nil {
+		// 列表未变化时也需要同步，释放超过保留时间的地址
+		r.vipAllocator.Sync(services)
+	}
+	if r.services != nil && !ifServiceListChanged(r.services, services) {
+		return
+	}
+	if r.services == nil {
+		r.localDNSServer.UpdateLookupTable(services, r.resolve)
+	} else {
+		added, removed := diffServices(r.services, services)
+		r.localDNSServer.ApplyServiceChanges(added, removed, r.resolve)
+	}
+	if services == nil {
+		services = map[string]struct{}{}
+	}
+	r.services = services
+	r.updateHostServices(services)
+	if len(r.config.SnapshotPath) > 0 {
+		r.localDNSServer.StoreSnapshot(r.config.SnapshotPath)
 	}
-	if ifServiceListChanged(currentServices, services) {
-		r.localDNSServer.UpdateLookupTable(services, r.config.DNSAnswerIp)
-		return services, true
+}
+
+// diffServices returns the services only exist in the new list and the services only exist in the current list
+func diffServices(currentServices, newServices map[string]struct{}) (map[string]struct{}, map[string]struct{}) {
+	added := map[string]struct{}{}
+	removed := map[string]struct{}{}
+	for svc := range newServices {
+		if _, ok := currentServices[svc]; !ok {
+			added[svc] = struct{}{}
+		}
+	}
+	for svc := range currentServices {
+		if _, ok := newServices[svc]; !ok {
+			removed[svc] = struct{}{}
+		}
 	}
-	return nil, false
+	return added, removed
 }
 
 func ifServiceListChanged(currentServices, newNsServices map[string]struct{}) bool {
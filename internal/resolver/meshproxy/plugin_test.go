@@ -0,0 +1,174 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package meshproxy
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolverMesh_applyServices(t *testing.T) {
+	server, err := newLocalDNSServer(10, false, "", nil)
+	assert.NoError(t, err)
+	r := &resolverMesh{
+		config:         &resolverConfig{},
+		localDNSServer: server,
+		answerIPv4:     []net.IP{net.ParseIP("10.4.4.4").To4()},
+	}
+	lookup := func(qname string) *dns.Msg {
+		return server.ServeDNS(context.Background(), &dns.Question{Name: qname, Qtype: dns.TypeA}, qname)
+	}
+
+	r.applyServices(map[string]struct{}{"foo.default": {}, "bar.default": {}})
+	assert.NotNil(t, lookup("foo.default."))
+	assert.NotNil(t, lookup("bar.default."))
+	oldTable := server.lookupTable.Load().(*LookupTable)
+
+	// 增量更新：删除 bar，新增 baz，旧的查找表不受影响
+	r.applyServices(map[string]struct{}{"foo.default": {}, "baz.default": {}})
+	assert.NotNil(t, lookup("foo.default."))
+	assert.Nil(t, lookup("bar.default."))
+	assert.NotNil(t, lookup("baz.default."))
+	assert.Contains(t, oldTable.allHosts, "bar.default.")
+	assert.NotContains(t, oldTable.allHosts, "baz.default.")
+
+	// 服务列表未变化时不重建查找表
+	table := server.lookupTable.Load().(*LookupTable)
+	r.applyServices(map[string]struct{}{"foo.default": {}, "baz.default": {}})
+	assert.Same(t, table, server.lookupTable.Load().(*LookupTable))
+}
+
+func TestLocalDNSServer_ServeSRV(t *testing.T) {
+	server, err := newLocalDNSServer(10, false, "", map[string]int{"grpc": 15001, AllProtocols: 15006})
+	assert.NoError(t, err)
+	server.servicePort = func(hostname string) (int, bool) {
+		return 8080, hostname == "bar.default."
+	}
+	server.UpdateLookupTable(map[string]struct{}{"foo.default": {}, "bar.default": {}}, func(service string) *serviceAnswer {
+		return &serviceAnswer{ipv4: []net.IP{net.ParseIP("10.4.4.4").To4()}}
+	})
+	lookup := func(qname string) *dns.Msg {
+		return server.ServeDNS(context.Background(), &dns.Question{Name: qname, Qtype: dns.TypeSRV}, qname)
+	}
+
+	resp := lookup("_grpc._tcp.foo.default.")
+	assert.Len(t, resp.Answer, 1)
+	srv := resp.Answer[0].(*dns.SRV)
+	assert.Equal(t, "_grpc._tcp.foo.default.", srv.Hdr.Name)
+	assert.Equal(t, "foo.default.", srv.Target)
+	assert.Equal(t, uint16(15001), srv.Port)
+	assert.Len(t, resp.Extra, 1)
+	assert.Equal(t, "10.4.4.4", resp.Extra[0].(*dns.A).A.String())
+
+	// 未配置的协议使用通配端口
+	resp = lookup("foo.default.")
+	assert.Equal(t, uint16(15006), resp.Answer[0].(*dns.SRV).Port)
+
+	// 服务元数据指定的端口优先
+	resp = lookup("_grpc._tcp.bar.default.")
+	assert.Equal(t, uint16(8080), resp.Answer[0].(*dns.SRV).Port)
+
+	assert.Nil(t, lookup("_grpc._tcp.baz.default."))
+}
+
+func TestLocalDNSServer_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	server, _ := newLocalDNSServer(10, false, "", map[string]int{AllProtocols: 15001})
+	server.UpdateLookupTable(map[string]struct{}{"foo.default": {}}, func(service string) *serviceAnswer {
+		return &serviceAnswer{ipv4: []net.IP{net.ParseIP("10.4.4.4").To4()}, ipv6: []net.IP{net.ParseIP("fd00::1")}}
+	})
+	server.StoreSnapshot(path)
+
+	// 重启后在加载服务列表之前即可从快照应答
+	restored, _ := newLocalDNSServer(10, false, "", nil)
+	restored.RestoreSnapshot(path)
+	assert.Equal(t, server.lookupTable.Load(), restored.lookupTable.Load())
+}
+
+func TestLocalDNSServer_ServeSVCB(t *testing.T) {
+	server, _ := newLocalDNSServer(10, false, "", map[string]int{AllProtocols: 15001})
+	server.UpdateLookupTable(map[string]struct{}{"foo.default": {}}, func(service string) *serviceAnswer {
+		return &serviceAnswer{ipv4: []net.IP{net.ParseIP("10.4.4.4").To4()}, ipv6: []net.IP{net.ParseIP("fd00::1")}}
+	})
+	lookup := func(qname string, qtype uint16) *dns.Msg {
+		return server.ServeDNS(context.Background(), &dns.Question{Name: qname, Qtype: qtype}, qname)
+	}
+
+	resp := lookup("foo.default.", dns.TypeHTTPS)
+	assert.Len(t, resp.Answer, 1)
+	https := resp.Answer[0].(*dns.HTTPS)
+	assert.Equal(t, uint16(1), https.Priority)
+	assert.Equal(t, ".", https.Target)
+	assert.Len(t, https.Value, 3)
+	assert.Equal(t, "15001", https.Value[0].String())
+	assert.Equal(t, "10.4.4.4", https.Value[1].String())
+	assert.Equal(t, "fd00::1", https.Value[2].String())
+
+	resp = lookup("foo.default.", dns.TypeSVCB)
+	assert.Equal(t, dns.TypeSVCB, resp.Answer[0].Header().Rrtype)
+
+	assert.Nil(t, lookup("bar.default.", dns.TypeHTTPS))
+}
+
+type fakeRegistry struct {
+	registry
+	metadata map[string]map[string]string
+	// metadataRequests count of the GetServiceMetadata calls
+	metadataRequests int
+}
+
+func (f *fakeRegistry) GetServiceMetadata(service string) (map[string]string, error) {
+	f.metadataRequests++
+	return f.metadata[service], nil
+}
+
+func TestResolverMesh_srvPort(t *testing.T) {
+	reg := &fakeRegistry{metadata: map[string]map[string]string{"Foo.default": {"mesh-port": "8080"}}}
+	server, _ := newLocalDNSServer(10, false, "", map[string]int{AllProtocols: 15001})
+	r := &resolverMesh{
+		config:         &resolverConfig{SRVPortMetadataKey: "mesh-port", ReloadIntervalSec: 30},
+		registry:       reg,
+		localDNSServer: server,
+		ports:          map[string]*cachedPort{},
+	}
+	server.servicePort = r.srvPort
+	lookup := func(qname string) *dns.Msg {
+		return server.ServeDNS(context.Background(), &dns.Question{Name: qname, Qtype: dns.TypeSRV}, qname)
+	}
+
+	// 重建查找表时不查询服务元数据
+	r.applyServices(map[string]struct{}{"Foo.default": {}, "bar.default": {}})
+	assert.Equal(t, 0, reg.metadataRequests)
+
+	// 首次 SRV 查询时按原始服务名查询元数据并缓存
+	assert.Equal(t, uint16(8080), lookup("_grpc._tcp.foo.default.").Answer[0].(*dns.SRV).Port)
+	assert.Equal(t, uint16(8080), lookup("_http._tcp.foo.default.").Answer[0].(*dns.SRV).Port)
+	assert.Equal(t, 1, reg.metadataRequests)
+	assert.Equal(t, uint16(15001), lookup("bar.default.").Answer[0].(*dns.SRV).Port)
+	assert.Equal(t, 2, reg.metadataRequests)
+
+	// 服务删除后清理缓存
+	r.applyServices(map[string]struct{}{"bar.default": {}})
+	assert.NotContains(t, r.ports, "foo.default.")
+	assert.Contains(t, r.ports, "bar.default.")
+}
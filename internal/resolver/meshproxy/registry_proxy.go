@@ -18,20 +18,32 @@
 package meshproxy
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/polarismesh/polaris-go"
+	"github.com/polarismesh/polaris-go/pkg/model"
 
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
+	"github.com/polarismesh/polaris-sidecar/pkg/utils"
 )
 
 type registry interface {
 	GetCurrentNsService() (map[string]struct{}, error)
+	// WatchServices subscribe the service list changes, onUpdate is called with the initial list and
+	// the full list on each change, the returned function cancels the subscription
+	WatchServices(onUpdate func(map[string]struct{})) (func(), error)
+	// GetServiceMetadata returns the metadata of the service in the format of <service>.<namespace>
+	GetServiceMetadata(service string) (map[string]string, error)
 }
 
 func newRegistry(conf *resolverConfig, consumer polaris.ConsumerAPI, business string) (registry, error) {
 	r := &envoyRegistry{
-		conf:     conf,
-		consumer: consumer,
-		business: business,
+		conf:       conf,
+		consumer:   consumer,
+		business:   business,
+		namespaces: scopeNamespaces(conf),
+		nsServices: map[string]map[string]struct{}{},
 	}
 	return r, nil
 }
@@ -40,25 +52,153 @@ type envoyRegistry struct {
 	conf     *resolverConfig
 	consumer polaris.ConsumerAPI
 	business string
+	// namespaces the namespaces to load, the empty namespace means all the namespaces
+	namespaces []string
+
+	mutex sync.Mutex
+	// nsServices the services of each namespace, the watch event only replaces the namespace it belongs to
+	nsServices map[string]map[string]struct{}
+}
+
+// scopeNamespaces returns the namespaces to load the services from, the wildcard loads all the namespaces
+func scopeNamespaces(conf *resolverConfig) []string {
+	for _, namespace := range conf.Namespaces {
+		if namespace == AllNamespaces {
+			return []string{""}
+		}
+	}
+	return utils.ResolveSearchNamespaces(conf.Namespace, conf.Namespaces)
 }
 
 func (r *envoyRegistry) GetCurrentNsService() (map[string]struct{}, error) {
-	var services map[string]struct{}
+	nsServices := make(map[string]map[string]struct{}, len(r.namespaces))
+	for _, namespace := range r.namespaces {
+		services, err := r.listServices(namespace)
+		if nil != err {
+			return nil, err
+		}
+		nsServices[namespace] = services
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.nsServices = nsServices
+	return r.mergeServices(), nil
+}
+
+// listServices returns the services of the namespace, the business and metadata filters are applied
+// by polaris, so that the instances of the services are never loaded
+func (r *envoyRegistry) listServices(namespace string) (map[string]struct{}, error) {
 	req := &polaris.GetServicesRequest{}
+	req.Namespace = namespace
 	req.Business = r.business
-	resp, err := r.consumer.GetServices(&polaris.GetServicesRequest{})
+	req.Metadata = r.conf.ServiceSelector
+	resp, err := r.consumer.GetServices(req)
 	if nil != err {
-		log.Errorf("[mesh] fail to request services from polaris, %v", err)
+		log.Errorf("[mesh] fail to request services of namespace %q from polaris, %v", namespace, err)
 		return nil, err
 	}
-	if len(resp.Value) == 0 {
+	return toServiceSet(resp.GetValue()), nil
+}
+
+func toServiceSet(svcKeys []*model.ServiceKey) map[string]struct{} {
+	services := make(map[string]struct{}, len(svcKeys))
+	for _, svc := range svcKeys {
+		// 这里必须全匹配的模式存储，服务名中的点需要转义，与 dns 报文中的表示保持一致
+		services[utils.EscapeLabel(svc.Service)+"."+svc.Namespace] = struct{}{}
+	}
+	return services
+}
+
+// mergeServices returns the services of all the namespaces, the caller must hold the mutex
+func (r *envoyRegistry) mergeServices() map[string]struct{} {
+	services := map[string]struct{}{}
+	for _, nsServices := range r.nsServices {
+		for svc := range nsServices {
+			services[svc] = struct{}{}
+		}
+	}
+	if len(services) == 0 {
 		log.Infof("[mesh] services is empty")
-		return services, nil
 	}
-	services = make(map[string]struct{}, len(resp.GetValue()))
-	for _, svc := range resp.GetValue() {
-		// 这里必须全匹配的模式存储
-		services[svc.Service+"."+svc.Namespace] = struct{}{}
+	return services
+}
+
+func (r *envoyRegistry) GetServiceMetadata(service string) (map[string]string, error) {
+	svcKeys := utils.ParseQnameCandidates(service, "", "", nil)
+	if len(svcKeys) != 1 {
+		return nil, fmt.Errorf("invalid service %s", service)
 	}
-	return services, nil
+	req := &polaris.GetAllInstancesRequest{}
+	req.Namespace = svcKeys[0].Namespace
+	req.Service = svcKeys[0].Service
+	resp, err := r.consumer.GetAllInstances(req)
+	if nil != err {
+		log.Errorf("[mesh] fail to get metadata of service %s, %v", *svcKeys[0], err)
+		return nil, err
+	}
+	return resp.GetMetadata(), nil
+}
+
+// WatchServices subscribe the service list of each namespace, the event replaces the services of the namespace.
+// The subscription does not support the business and metadata filters, so the namespace is listed again
+// with the filters when any of them is configured.
+func (r *envoyRegistry) WatchServices(onUpdate func(map[string]struct{})) (func(), error) {
+	cancels := make([]func(), 0, len(r.namespaces))
+	cancelAll := func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+	for _, namespace := range r.namespaces {
+		req := &polaris.WatchAllServicesRequest{}
+		req.Namespace = namespace
+		req.WatchMode = model.WatchModeNotify
+		req.ServicesListener = &servicesListener{namespace: namespace, onUpdate: func(namespace string,
+			resp *model.ServicesResponse) {
+			services, ok := r.applyEvent(namespace, resp)
+			if ok {
+				onUpdate(services)
+			}
+		}}
+		resp, err := r.consumer.WatchAllServices(req)
+		if nil != err {
+			log.Errorf("[mesh] fail to watch services of namespace %q from polaris, %v", namespace, err)
+			cancelAll()
+			return nil, err
+		}
+		cancels = append(cancels, resp.CancelWatch)
+	}
+	services, err := r.GetCurrentNsService()
+	if nil == err {
+		onUpdate(services)
+	}
+	return cancelAll, nil
+}
+
+// applyEvent replace the services of the namespace with the event, and returns the services of all the namespaces
+func (r *envoyRegistry) applyEvent(namespace string, resp *model.ServicesResponse) (map[string]struct{}, bool) {
+	var services map[string]struct{}
+	if len(r.business) > 0 || len(r.conf.ServiceSelector) > 0 {
+		var err error
+		if services, err = r.listServices(namespace); nil != err {
+			return nil, false
+		}
+	} else {
+		services = toServiceSet(resp.GetValue())
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.nsServices[namespace] = services
+	return r.mergeServices(), true
+}
+
+// servicesListener apply the service list of the watched namespace on change
+type servicesListener struct {
+	namespace string
+	onUpdate  func(namespace string, resp *model.ServicesResponse)
+}
+
+// OnServicesUpdate notify when service list changed
+func (l *servicesListener) OnServicesUpdate(resp *model.ServicesResponse) {
+	l.onUpdate(l.namespace, resp)
 }
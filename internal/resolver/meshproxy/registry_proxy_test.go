@@ -0,0 +1,152 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package meshproxy
+
+import (
+	"testing"
+
+	"github.com/polarismesh/polaris-go"
+	"github.com/polarismesh/polaris-go/pkg/config"
+	"github.com/polarismesh/polaris-go/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeConsumer struct {
+	polaris.ConsumerAPI
+	services  map[string][]*model.ServiceKey
+	metadata  map[string]map[string]string
+	requests  []*polaris.GetServicesRequest
+	listeners map[string]model.ServicesListener
+	// instanceRequests count of the GetAllInstances calls
+	instanceRequests int
+}
+
+// GetServices filter the services by the metadata like polaris does
+func (c *fakeConsumer) GetServices(req *polaris.GetServicesRequest) (*model.ServicesResponse, error) {
+	c.requests = append(c.requests, req)
+	var svcKeys []*model.ServiceKey
+	for namespace, nsSvcKeys := range c.services {
+		if len(req.Namespace) > 0 && req.Namespace != namespace {
+			continue
+		}
+		for _, svcKey := range nsSvcKeys {
+			if matchMetadata(c.metadata[svcKey.Service], req.Metadata) {
+				svcKeys = append(svcKeys, svcKey)
+			}
+		}
+	}
+	return &model.ServicesResponse{Value: svcKeys}, nil
+}
+
+func matchMetadata(metadata map[string]string, selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, ok := metadata[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *fakeConsumer) GetAllInstances(req *polaris.GetAllInstancesRequest) (*model.InstancesResponse, error) {
+	c.instanceRequests++
+	resp := &model.InstancesResponse{}
+	resp.Metadata = c.metadata[req.Service]
+	return resp, nil
+}
+
+func (c *fakeConsumer) WatchAllServices(req *polaris.WatchAllServicesRequest) (*model.WatchAllServicesResponse, error) {
+	if c.listeners == nil {
+		c.listeners = map[string]model.ServicesListener{}
+	}
+	c.listeners[req.Namespace] = req.ServicesListener
+	return model.NewWatchAllServicesResponse(0, nil, func(uint64) {}), nil
+}
+
+func TestEnvoyRegistry_GetCurrentNsService(t *testing.T) {
+	consumer := &fakeConsumer{
+		services: map[string][]*model.ServiceKey{
+			"default":              {{Namespace: "default", Service: "foo"}, {Namespace: "default", Service: "bar"}},
+			"test":                 {{Namespace: "test", Service: "baz"}},
+			config.ServerNamespace: {{Namespace: config.ServerNamespace, Service: "polaris.checker"}},
+		},
+		metadata: map[string]map[string]string{
+			"foo": {"env": "prod"},
+			"bar": {"env": "dev"},
+		},
+	}
+
+	// 通配符加载全部命名空间
+	conf, _ := parseOptions(map[string]interface{}{})
+	conf.Namespace = "default"
+	r, _ := newRegistry(conf, consumer, "biz")
+	services, err := r.GetCurrentNsService()
+	assert.NoError(t, err)
+	assert.Len(t, services, 4)
+	assert.Contains(t, services, "polaris\\.checker."+config.ServerNamespace)
+	assert.Equal(t, "biz", consumer.requests[0].Business)
+
+	// 按命名空间加载
+	conf, _ = parseOptions(map[string]interface{}{"namespaces": []string{"current", "test"}})
+	conf.Namespace = "default"
+	r, _ = newRegistry(conf, consumer, "")
+	services, err = r.GetCurrentNsService()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"foo.default": {}, "bar.default": {}, "baz.test": {}}, services)
+
+	// 按服务元数据过滤
+	conf.ServiceSelector = map[string]string{"env": "prod"}
+	services, err = r.GetCurrentNsService()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"foo.default": {}}, services)
+	assert.Equal(t, map[string]string{"env": "prod"}, consumer.requests[len(consumer.requests)-1].Metadata)
+	assert.Equal(t, 0, consumer.instanceRequests)
+}
+
+func TestEnvoyRegistry_WatchServices(t *testing.T) {
+	consumer := &fakeConsumer{
+		services: map[string][]*model.ServiceKey{
+			"default": {{Namespace: "default", Service: "foo"}},
+			"test":    {{Namespace: "test", Service: "baz"}},
+		},
+	}
+	conf, _ := parseOptions(map[string]interface{}{"namespaces": []string{"current", "test"}})
+	conf.Namespace = "default"
+	r, _ := newRegistry(conf, consumer, "")
+	var updated map[string]struct{}
+	cancel, err := r.WatchServices(func(services map[string]struct{}) {
+		updated = services
+	})
+	assert.NoError(t, err)
+	defer cancel()
+	assert.Equal(t, map[string]struct{}{"foo.default": {}, "baz.test": {}}, updated)
+	requests := len(consumer.requests)
+
+	// 直接应用事件中的服务列表，只替换事件所属的命名空间
+	consumer.listeners["default"].OnServicesUpdate(&model.ServicesResponse{Value: []*model.ServiceKey{
+		{Namespace: "default", Service: "foo"}, {Namespace: "default", Service: "bar"}}})
+	assert.Equal(t, map[string]struct{}{"foo.default": {}, "bar.default": {}, "baz.test": {}}, updated)
+	assert.Len(t, consumer.requests, requests)
+
+	// 配置了元数据过滤时只重新加载事件所属的命名空间
+	conf.ServiceSelector = map[string]string{"env": "prod"}
+	consumer.metadata = map[string]map[string]string{"foo": {"env": "prod"}}
+	consumer.listeners["default"].OnServicesUpdate(&model.ServicesResponse{})
+	assert.Equal(t, map[string]struct{}{"foo.default": {}, "baz.test": {}}, updated)
+	assert.Len(t, consumer.requests, requests+1)
+	assert.Equal(t, "default", consumer.requests[requests].Namespace)
+}
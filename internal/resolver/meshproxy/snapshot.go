@@ -0,0 +1,96 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package meshproxy
+
+import (
+	"net"
+	"sort"
+
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+// hostSnapshot the answers of a host persisted in the snapshot file
+type hostSnapshot struct {
+	Host string   `json:"host"`
+	IPv4 []string `json:"ipv4,omitempty"`
+	IPv6 []string `json:"ipv6,omitempty"`
+}
+
+// snapshot returns the hosts of the table sorted by name
+func (table *LookupTable) snapshot() []*hostSnapshot {
+	hosts := make([]*hostSnapshot, 0, len(table.allHosts))
+	for h := range table.allHosts {
+		host := &hostSnapshot{Host: h}
+		for _, ip := range table.name4[h] {
+			host.IPv4 = append(host.IPv4, ip.String())
+		}
+		for _, ip := range table.name6[h] {
+			host.IPv6 = append(host.IPv6, ip.String())
+		}
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Host < hosts[j].Host
+	})
+	return hosts
+}
+
+// StoreSnapshot write the current lookup table to the snapshot file
+func (h *LocalDNSServer) StoreSnapshot(path string) {
+	lp := h.lookupTable.Load()
+	if lp == nil {
+		return
+	}
+	if err := common.WriteSnapshot(path, lp.(*LookupTable).snapshot(), 0644); err != nil {
+		log.Errorf("[mesh] fail to write snapshot %s, err: %v", path, err)
+	}
+}
+
+// RestoreSnapshot build the lookup table from the snapshot file, so that the queries can be answered
+// before the service list is loaded from polaris, the table is replaced once the live data arrives
+func (h *LocalDNSServer) RestoreSnapshot(path string) {
+	var hosts []*hostSnapshot
+	exists, err := common.ReadSnapshot(path, &hosts)
+	if err != nil {
+		log.Errorf("[mesh] fail to read snapshot %s, err: %v", path, err)
+		return
+	}
+	if !exists {
+		return
+	}
+	h.updateMutex.Lock()
+	defer h.updateMutex.Unlock()
+	lookupTable := newLookupTable(h.dnsTtl)
+	for _, host := range hosts {
+		answer := &serviceAnswer{}
+		for _, value := range host.IPv4 {
+			if ip := net.ParseIP(value).To4(); ip != nil {
+				answer.ipv4 = append(answer.ipv4, ip)
+			}
+		}
+		for _, value := range host.IPv6 {
+			if ip := net.ParseIP(value); ip != nil {
+				answer.ipv6 = append(answer.ipv6, ip)
+			}
+		}
+		lookupTable.buildDNSAnswers(map[string]struct{}{host.Host: {}}, answer)
+	}
+	h.lookupTable.Store(lookupTable)
+	log.Infof("[mesh] restored lookup table with %d hosts from snapshot %s", len(lookupTable.allHosts), path)
+}
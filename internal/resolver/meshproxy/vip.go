@@ -0,0 +1,313 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package meshproxy
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	// DefaultVIPStorePath default file to persist the virtual ip allocation table
+	DefaultVIPStorePath = "/tmp/polaris-sidecar/meshproxy/vip.json"
+	// DefaultVIPReleaseDelay default duration to keep the vip of a service missing from the service list
+	DefaultVIPReleaseDelay = 10 * time.Minute
+)
+
+// VirtualIP the virtual ips allocated to a service
+type VirtualIP struct {
+	// Host service host in the format of <service>.<namespace>
+	Host string `json:"host"`
+	IPv4 string `json:"ipv4,omitempty"`
+	IPv6 string `json:"ipv6,omitempty"`
+	// missingSince when the service dropped out of the service list, zero if it is present
+	missingSince time.Time
+}
+
+// vipPool allocate ips from a cidr, the ip is chosen by the hash of the host so that
+// the allocation keeps stable even if the table is lost
+type vipPool struct {
+	ipNet *net.IPNet
+	// size count of the available ips
+	size uint64
+	// reserved count of the ips skipped at the beginning of the cidr
+	reserved uint64
+	used     map[string]string
+}
+
+func newVIPPool(cidr string) (*vipPool, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vip cidr %s: %w", cidr, err)
+	}
+	ones, bits := ipNet.Mask.Size()
+	hostBits := bits - ones
+	// 地址空间过大时只使用前 2^62 个地址
+	if hostBits > 62 {
+		hostBits = 62
+	}
+	pool := &vipPool{ipNet: ipNet, used: map[string]string{}}
+	pool.size = uint64(1) << uint(hostBits)
+	if bits == net.IPv4len*8 && pool.size > 2 {
+		// 跳过网络地址与广播地址
+		pool.size -= 2
+		pool.reserved = 1
+	}
+	return pool, nil
+}
+
+func (p *vipPool) ipAt(offset uint64) net.IP {
+	base := new(big.Int).SetBytes(p.ipNet.IP)
+	base.Add(base, new(big.Int).SetUint64(offset+p.reserved))
+	ip := make(net.IP, len(p.ipNet.IP))
+	raw := base.Bytes()
+	copy(ip[len(ip)-len(raw):], raw)
+	return ip
+}
+
+// offsetOf returns the offset of the ip, it returns false if the ip is not allocatable from the pool
+func (p *vipPool) offsetOf(ip net.IP) (uint64, bool) {
+	if !p.ipNet.Contains(ip) {
+		return 0, false
+	}
+	if len(p.ipNet.IP) == net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	offset := new(big.Int).Sub(new(big.Int).SetBytes(ip), new(big.Int).SetBytes(p.ipNet.IP))
+	offset.Sub(offset, new(big.Int).SetUint64(p.reserved))
+	if offset.Sign() < 0 || !offset.IsUint64() || offset.Uint64() >= p.size {
+		return 0, false
+	}
+	return offset.Uint64(), true
+}
+
+func (p *vipPool) allocate(host string) (string, error) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(host))
+	offset := h.Sum64() % p.size
+	if uint64(len(p.used)) < p.size {
+		// used 中只有可分配范围内的地址，最多探测 size 次
+		for i := uint64(0); i < p.size; i++ {
+			ip := p.ipAt(offset).String()
+			if _, ok := p.used[ip]; !ok {
+				p.used[ip] = host
+				return ip, nil
+			}
+			offset = (offset + 1) % p.size
+		}
+	}
+	return "", errors.New("vip cidr " + p.ipNet.String() + " is exhausted")
+}
+
+// occupy mark the ip as used by the host, it returns false if the ip is not allocatable from the cidr
+// or used by others
+func (p *vipPool) occupy(ip string, host string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	if _, ok := p.offsetOf(parsed); !ok {
+		return false
+	}
+	ip = parsed.String()
+	if owner, ok := p.used[ip]; ok && owner != host {
+		return false
+	}
+	p.used[ip] = host
+	return true
+}
+
+func (p *vipPool) release(ip string) {
+	delete(p.used, ip)
+}
+
+// vipAllocator allocate the virtual ips to services and persist the table
+type vipAllocator struct {
+	mutex     sync.RWMutex
+	v4        *vipPool
+	v6        *vipPool
+	storePath string
+	vips      map[string]*VirtualIP
+	// releaseDelay the vip of a service missing from the service list is kept for the duration,
+	// so that a flapping service does not lose its vip to others
+	releaseDelay time.Duration
+	now          func() time.Time
+}
+
+func newVIPAllocator(cidr string, cidrV6 string, storePath string, releaseDelay time.Duration) (*vipAllocator, error) {
+	a := &vipAllocator{
+		storePath:    storePath,
+		vips:         map[string]*VirtualIP{},
+		releaseDelay: releaseDelay,
+		now:          time.Now,
+	}
+	var err error
+	if len(cidr) > 0 {
+		if a.v4, err = newVIPPool(cidr); err != nil {
+			return nil, err
+		}
+	}
+	if len(cidrV6) > 0 {
+		if a.v6, err = newVIPPool(cidrV6); err != nil {
+			return nil, err
+		}
+	}
+	if a.v4 == nil && a.v6 == nil {
+		return nil, errors.New("at least one of vip_cidr and vip_cidr_v6 should be provided")
+	}
+	a.load()
+	return a, nil
+}
+
+// load restore the allocation table from the store file, entries out of the cidr are dropped
+func (a *vipAllocator) load() {
+	if len(a.storePath) == 0 {
+		return
+	}
+	var vips []*VirtualIP
+	exists, err := common.ReadSnapshot(a.storePath, &vips)
+	if err != nil {
+		log.Errorf("[mesh] fail to read vip table %s, err: %v", a.storePath, err)
+		return
+	}
+	if !exists {
+		return
+	}
+	for _, vip := range vips {
+		restored := &VirtualIP{Host: vip.Host}
+		if a.v4 != nil && len(vip.IPv4) > 0 && a.v4.occupy(vip.IPv4, vip.Host) {
+			restored.IPv4 = vip.IPv4
+		}
+		if a.v6 != nil && len(vip.IPv6) > 0 && a.v6.occupy(vip.IPv6, vip.Host) {
+			restored.IPv6 = vip.IPv6
+		}
+		a.vips[vip.Host] = restored
+	}
+	log.Infof("[mesh] restored %d vips from %s", len(a.vips), a.storePath)
+}
+
+// Sync allocate vips for the new hosts and release the vips of the hosts removed longer than the release delay
+func (a *vipAllocator) Sync(hosts map[string]struct{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	changed := false
+	now := a.now()
+	for host, vip := range a.vips {
+		if _, ok := hosts[host]; ok {
+			vip.missingSince = time.Time{}
+			continue
+		}
+		if vip.missingSince.IsZero() {
+			vip.missingSince = now
+		}
+		if now.Sub(vip.missingSince) < a.releaseDelay {
+			continue
+		}
+		if a.v4 != nil && len(vip.IPv4) > 0 {
+			a.v4.release(vip.IPv4)
+		}
+		if a.v6 != nil && len(vip.IPv6) > 0 {
+			a.v6.release(vip.IPv6)
+		}
+		delete(a.vips, host)
+		changed = true
+	}
+	for host := range hosts {
+		vip, ok := a.vips[host]
+		if !ok {
+			vip = &VirtualIP{Host: host}
+			a.vips[host] = vip
+		}
+		if a.v4 != nil && len(vip.IPv4) == 0 {
+			ip, err := a.v4.allocate(host)
+			if err != nil {
+				log.Errorf("[mesh] fail to allocate ipv4 vip for %s, err: %v", host, err)
+			}
+			vip.IPv4 = ip
+			changed = changed || len(ip) > 0
+		}
+		if a.v6 != nil && len(vip.IPv6) == 0 {
+			ip, err := a.v6.allocate(host)
+			if err != nil {
+				log.Errorf("[mesh] fail to allocate ipv6 vip for %s, err: %v", host, err)
+			}
+			vip.IPv6 = ip
+			changed = changed || len(ip) > 0
+		}
+	}
+	if changed {
+		a.store()
+	}
+}
+
+// Lookup returns the vips allocated to the host
+func (a *vipAllocator) Lookup(host string) ([]net.IP, []net.IP) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	vip, ok := a.vips[host]
+	if !ok {
+		return nil, nil
+	}
+	var ipv4, ipv6 []net.IP
+	if ip := net.ParseIP(vip.IPv4); ip != nil {
+		ipv4 = append(ipv4, ip.To4())
+	}
+	if ip := net.ParseIP(vip.IPv6); ip != nil {
+		ipv6 = append(ipv6, ip)
+	}
+	return ipv4, ipv6
+}
+
+// List returns the allocation table sorted by host
+func (a *vipAllocator) List() []*VirtualIP {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.list()
+}
+
+func (a *vipAllocator) list() []*VirtualIP {
+	vips := make([]*VirtualIP, 0, len(a.vips))
+	for _, vip := range a.vips {
+		vips = append(vips, &VirtualIP{Host: vip.Host, IPv4: vip.IPv4, IPv6: vip.IPv6})
+	}
+	sort.Slice(vips, func(i, j int) bool {
+		return vips[i].Host < vips[j].Host
+	})
+	return vips
+}
+
+// store write the table to a temporary file and rename it, so that the store file is always complete
+func (a *vipAllocator) store() {
+	if len(a.storePath) == 0 {
+		return
+	}
+	if err := common.WriteSnapshot(a.storePath, a.list(), 0644); err != nil {
+		log.Errorf("[mesh] fail to write vip table %s, err: %v", a.storePath, err)
+	}
+}
@@ -0,0 +1,125 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package meshproxy
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
+)
+
+func TestVIPAllocator(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "vip.json")
+	allocator, err := newVIPAllocator("240.0.0.0/30", "fd00::/120", storePath, 0)
+	assert.NoError(t, err)
+
+	allocator.Sync(map[string]struct{}{"foo.default": {}, "bar.default": {}})
+	fooV4, fooV6 := allocator.Lookup("foo.default")
+	barV4, barV6 := allocator.Lookup("bar.default")
+	assert.Len(t, fooV4, 1)
+	assert.Len(t, fooV6, 1)
+	assert.NotEqual(t, fooV4[0].String(), barV4[0].String())
+	assert.NotEqual(t, fooV6[0].String(), barV6[0].String())
+	_, ipNet, _ := net.ParseCIDR("240.0.0.0/30")
+	for _, ip := range []net.IP{fooV4[0], barV4[0]} {
+		assert.True(t, ipNet.Contains(ip))
+		// 不分配网络地址与广播地址
+		assert.NotEqual(t, "240.0.0.0", ip.String())
+		assert.NotEqual(t, "240.0.0.3", ip.String())
+	}
+
+	// 地址池耗尽时不分配 ipv4 地址
+	allocator.Sync(map[string]struct{}{"foo.default": {}, "bar.default": {}, "baz.default": {}})
+	bazV4, bazV6 := allocator.Lookup("baz.default")
+	assert.Len(t, bazV4, 0)
+	assert.Len(t, bazV6, 1)
+
+	// 重启后从文件恢复相同的分配结果
+	restored, err := newVIPAllocator("240.0.0.0/30", "fd00::/120", storePath, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, allocator.List(), restored.List())
+
+	// 服务删除后释放地址
+	restored.Sync(map[string]struct{}{"foo.default": {}})
+	assert.Len(t, restored.List(), 1)
+	restoredV4, _ := restored.Lookup("foo.default")
+	assert.Equal(t, fooV4[0].String(), restoredV4[0].String())
+}
+
+func TestVIPAllocator_ReleaseDelay(t *testing.T) {
+	allocator, err := newVIPAllocator("240.0.0.0/30", "", "", time.Minute)
+	assert.NoError(t, err)
+	now := time.Now()
+	allocator.now = func() time.Time { return now }
+
+	allocator.Sync(map[string]struct{}{"foo.default": {}, "bar.default": {}})
+	fooV4, _ := allocator.Lookup("foo.default")
+	assert.Len(t, fooV4, 1)
+
+	// 服务短暂消失时保留地址，其他服务无法占用
+	allocator.Sync(map[string]struct{}{"bar.default": {}, "baz.default": {}})
+	bazV4, _ := allocator.Lookup("baz.default")
+	assert.Len(t, bazV4, 0)
+	allocator.Sync(map[string]struct{}{"foo.default": {}, "bar.default": {}})
+	restoredV4, _ := allocator.Lookup("foo.default")
+	assert.Equal(t, fooV4, restoredV4)
+
+	// 超过保留时间后释放
+	allocator.Sync(map[string]struct{}{"bar.default": {}})
+	now = now.Add(time.Minute)
+	allocator.Sync(map[string]struct{}{"bar.default": {}, "baz.default": {}})
+	bazV4, _ = allocator.Lookup("baz.default")
+	assert.Equal(t, fooV4, bazV4)
+	fooV4, _ = allocator.Lookup("foo.default")
+	assert.Len(t, fooV4, 0)
+}
+
+func TestVIPAllocator_RestoreOutOfRange(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "vip.json")
+	// 网络地址、广播地址与 cidr 外的地址不可恢复
+	assert.NoError(t, common.WriteSnapshot(storePath, []*VirtualIP{
+		{Host: "network.default", IPv4: "240.0.0.0"},
+		{Host: "broadcast.default", IPv4: "240.0.0.3"},
+		{Host: "outside.default", IPv4: "240.0.0.4"},
+		{Host: "foo.default", IPv4: "240.0.0.1"},
+	}, 0644))
+	allocator, err := newVIPAllocator("240.0.0.0/30", "", storePath, 0)
+	assert.NoError(t, err)
+	assert.Len(t, allocator.v4.used, 1)
+
+	hosts := map[string]struct{}{"network.default": {}, "broadcast.default": {}, "outside.default": {},
+		"foo.default": {}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		allocator.Sync(hosts)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("allocate does not return when the pool is exhausted")
+	}
+	assert.Len(t, allocator.v4.used, 2)
+	fooV4, _ := allocator.Lookup("foo.default")
+	assert.Equal(t, "240.0.0.1", fooV4[0].String())
+}
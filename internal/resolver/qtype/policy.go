@@ -0,0 +1,145 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package qtype
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ActionResolve resolve the query by the resolvers, it is always used for A/AAAA/SRV
+	ActionResolve = "resolve"
+	// ActionSynthesize synthesize the SVCB/HTTPS records from the polaris instances
+	ActionSynthesize = "synthesize"
+	// ActionNodata answer NOERROR with no records locally
+	ActionNodata = "nodata"
+	// ActionForward forward the query to the upstream nameservers
+	ActionForward = "forward"
+
+	wildcard = "*"
+)
+
+// Config the policy of the query types other than A/AAAA/SRV
+type Config struct {
+	// Rules the ordered rules, the first matched rule takes effect,
+	// the queries matching none of the rules are forwarded to the upstream nameservers
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Rule decide how to answer the query types in the zones
+type Rule struct {
+	// Types the query types such as HTTPS, SVCB, MX, "*" or empty means all the types
+	Types []string `yaml:"types"`
+	// Zones the zones of the query names, a zone matches itself and all the sub domains, empty means all the names
+	Zones []string `yaml:"zones"`
+	// Action one of synthesize, nodata, forward
+	Action string `yaml:"action"`
+
+	qtypes map[uint16]struct{}
+}
+
+// Policy decide the action of each query
+type Policy struct {
+	rules []*Rule
+}
+
+// New build the policy, it returns nil if there is no rule
+func New(config *Config) (*Policy, error) {
+	if config == nil || len(config.Rules) == 0 {
+		return nil, nil
+	}
+	p := &Policy{}
+	for idx, rule := range config.Rules {
+		switch rule.Action {
+		case ActionSynthesize, ActionNodata, ActionForward:
+		default:
+			return nil, fmt.Errorf("qtype rule %d action should be one of synthesize, nodata, forward", idx)
+		}
+		rule.qtypes = make(map[uint16]struct{}, len(rule.Types))
+		for _, typeName := range rule.Types {
+			if typeName == wildcard {
+				rule.qtypes = map[uint16]struct{}{}
+				break
+			}
+			qtype, ok := dns.StringToType[strings.ToUpper(typeName)]
+			if !ok {
+				return nil, fmt.Errorf("qtype rule %d has unknown type %s", idx, typeName)
+			}
+			rule.qtypes[qtype] = struct{}{}
+		}
+		if rule.Action == ActionSynthesize {
+			if len(rule.qtypes) == 0 {
+				return nil, fmt.Errorf("qtype rule %d should specify the types to synthesize", idx)
+			}
+			for qtype := range rule.qtypes {
+				if !CanSynthesize(qtype) {
+					return nil, fmt.Errorf("qtype rule %d can not synthesize type %s", idx, dns.TypeToString[qtype])
+				}
+			}
+		}
+		for i := range rule.Zones {
+			rule.Zones[i] = strings.ToLower(dns.Fqdn(rule.Zones[i]))
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// CanSynthesize returns whether the records of the type can be synthesized from the polaris instances
+func CanSynthesize(qtype uint16) bool {
+	return qtype == dns.TypeHTTPS || qtype == dns.TypeSVCB
+}
+
+// Action returns the action of the query, the policy can be nil
+func (p *Policy) Action(qname string, qtype uint16) string {
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeSRV:
+		return ActionResolve
+	}
+	if p == nil {
+		return ActionForward
+	}
+	qname = strings.ToLower(dns.Fqdn(qname))
+	for _, rule := range p.rules {
+		if len(rule.qtypes) > 0 {
+			if _, ok := rule.qtypes[qtype]; !ok {
+				continue
+			}
+		}
+		if !matchZones(rule.Zones, qname) {
+			continue
+		}
+		return rule.Action
+	}
+	return ActionForward
+}
+
+func matchZones(zones []string, qname string) bool {
+	if len(zones) == 0 {
+		return true
+	}
+	for _, zone := range zones {
+		if dns.IsSubDomain(zone, qname) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package qtype
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Action(t *testing.T) {
+	policy, err := New(&Config{Rules: []*Rule{
+		{Types: []string{"https", "SVCB"}, Zones: []string{"default"}, Action: ActionSynthesize},
+		{Types: []string{"MX"}, Zones: []string{"default."}, Action: ActionForward},
+		{Zones: []string{"default.", "prod."}, Action: ActionNodata},
+	}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, ActionResolve, policy.Action("foo.default.", dns.TypeA))
+	assert.Equal(t, ActionResolve, policy.Action("foo.default.", dns.TypeSRV))
+	assert.Equal(t, ActionSynthesize, policy.Action("foo.default.", dns.TypeHTTPS))
+	assert.Equal(t, ActionSynthesize, policy.Action("Foo.Default", dns.TypeSVCB))
+	assert.Equal(t, ActionForward, policy.Action("foo.default.", dns.TypeMX))
+	assert.Equal(t, ActionNodata, policy.Action("foo.default.", dns.TypeCAA))
+	assert.Equal(t, ActionNodata, policy.Action("prod.", dns.TypeNS))
+	// 不属于任何规则的区域
+	assert.Equal(t, ActionNodata, policy.Action("foo.prod.", dns.TypeHTTPS))
+	assert.Equal(t, ActionForward, policy.Action("example.com.", dns.TypeHTTPS))
+	assert.Equal(t, ActionForward, policy.Action("nodefault.", dns.TypeCAA))
+
+	// 未配置规则时全部转发
+	var empty *Policy
+	assert.Equal(t, ActionForward, empty.Action("foo.default.", dns.TypeHTTPS))
+	assert.Equal(t, ActionResolve, empty.Action("foo.default.", dns.TypeAAAA))
+}
+
+func TestNew(t *testing.T) {
+	policy, err := New(&Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+
+	_, err = New(&Config{Rules: []*Rule{{Types: []string{"MX"}, Action: ActionSynthesize}}})
+	assert.Error(t, err)
+	_, err = New(&Config{Rules: []*Rule{{Action: ActionSynthesize}}})
+	assert.Error(t, err)
+	_, err = New(&Config{Rules: []*Rule{{Types: []string{"UNKNOWN"}, Action: ActionNodata}}})
+	assert.Error(t, err)
+	_, err = New(&Config{Rules: []*Rule{{Action: "drop"}}})
+	assert.Error(t, err)
+}
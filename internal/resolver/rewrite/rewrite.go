@@ -0,0 +1,122 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package rewrite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// TypeExact replace the query name equals to from
+	TypeExact = "exact"
+	// TypeSuffix replace the suffix of the query name
+	TypeSuffix = "suffix"
+	// TypeRegex replace the query name matching the regular expression, the submatches can be referenced
+	// in the replacement as $1, $2...
+	TypeRegex = "regex"
+)
+
+// Rule rewrite the query name before dispatching to the resolvers
+type Rule struct {
+	Type string `yaml:"type"`
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+
+	regex *regexp.Regexp
+}
+
+// Rewriter apply the first matched rule to the query name
+type Rewriter struct {
+	rules []*Rule
+}
+
+// New build the rewriter, it returns nil if there is no rule
+func New(rules []*Rule) (*Rewriter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Rewriter{}
+	for idx, rule := range rules {
+		switch rule.Type {
+		case TypeExact, TypeSuffix:
+			if rule.Type == TypeSuffix {
+				// 后缀按标签匹配，忽略开头的点
+				rule.From = strings.TrimLeft(rule.From, ".")
+			}
+			rule.From = strings.ToLower(dns.Fqdn(rule.From))
+			rule.To = strings.ToLower(dns.Fqdn(rule.To))
+		case TypeRegex:
+			regex, err := regexp.Compile(rule.From)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %d has invalid regex %s: %w", idx, rule.From, err)
+			}
+			rule.regex = regex
+		default:
+			return nil, fmt.Errorf("rewrite rule %d type should be one of exact, suffix, regex", idx)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// Rewrite returns the rewritten query name, and whether any rule is matched
+func (r *Rewriter) Rewrite(qname string) (string, bool) {
+	qname = strings.ToLower(dns.Fqdn(qname))
+	for _, rule := range r.rules {
+		switch rule.Type {
+		case TypeExact:
+			if qname == rule.From {
+				return rule.To, true
+			}
+		case TypeSuffix:
+			if dns.IsSubDomain(rule.From, qname) {
+				return joinName(qname[:len(qname)-len(rule.From)], rule.To), true
+			}
+		case TypeRegex:
+			if rule.regex.MatchString(qname) {
+				return dns.Fqdn(rule.regex.ReplaceAllString(qname, rule.To)), true
+			}
+		}
+	}
+	return qname, false
+}
+
+// joinName join the remaining labels and the replaced suffix
+func joinName(prefix string, suffix string) string {
+	prefix = strings.Trim(prefix, ".")
+	suffix = strings.Trim(suffix, ".")
+	if len(prefix) == 0 || len(suffix) == 0 {
+		return dns.Fqdn(prefix + suffix)
+	}
+	return dns.Fqdn(prefix + "." + suffix)
+}
+
+// RestoreNames set the owner names of the records carrying the rewritten name back to the original question name
+func RestoreNames(msg *dns.Msg, rewritten string, original string) {
+	for _, records := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range records {
+			if rr != nil && strings.EqualFold(rr.Header().Name, rewritten) {
+				rr.Header().Name = original
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package rewrite
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriter(t *testing.T) {
+	r, err := New(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+	_, err = New([]*Rule{{Type: "prefix"}})
+	assert.Error(t, err)
+	_, err = New([]*Rule{{Type: TypeRegex, From: "("}})
+	assert.Error(t, err)
+
+	r, err = New([]*Rule{
+		{Type: TypeExact, From: "legacy-foo", To: "foo.prod"},
+		{Type: TypeRegex, From: `^([^.]+)\.([^.]+)\.svc\.cluster\.local\.$`, To: "$1.$2."},
+		{Type: TypeSuffix, From: "legacy.example", To: "."},
+		{Type: TypeSuffix, From: ".corp.", To: "prod."},
+	})
+	assert.NoError(t, err)
+
+	tests := map[string]string{
+		"legacy-foo.":                     "foo.prod.",
+		"Legacy-Foo":                      "foo.prod.",
+		"foo.default.svc.cluster.local.":  "foo.default.",
+		"bar.legacy.example.":             "bar.",
+		"bar.corp.":                       "bar.prod.",
+		"legacy.example.":                 ".",
+		"xlegacy.example.":                "",
+		"bar.xcorp.":                      "",
+		"foo.bar.default.svc.cluster.lo.": "",
+	}
+	for qname, want := range tests {
+		got, matched := r.Rewrite(qname)
+		if len(want) == 0 {
+			assert.False(t, matched, qname)
+			continue
+		}
+		assert.True(t, matched, qname)
+		assert.Equal(t, want, got, qname)
+	}
+
+	msg := &dns.Msg{Answer: []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "foo.prod.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+		A:   net.ParseIP("127.0.0.1"),
+	}}}
+	RestoreNames(msg, "foo.prod.", "legacy-foo.")
+	assert.Equal(t, "legacy-foo.", msg.Answer[0].Header().Name)
+}
@@ -27,15 +27,22 @@ import (
 	"github.com/miekg/dns"
 
 	debughttp "github.com/polarismesh/polaris-sidecar/internal/debugger"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/acl"
 	"github.com/polarismesh/polaris-sidecar/internal/resolver/common"
 	_ "github.com/polarismesh/polaris-sidecar/internal/resolver/dnsagent"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/limiter"
 	_ "github.com/polarismesh/polaris-sidecar/internal/resolver/meshproxy"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/qtype"
 	"github.com/polarismesh/polaris-sidecar/internal/resolver/recursor"
+	"github.com/polarismesh/polaris-sidecar/internal/resolver/rewrite"
 	"github.com/polarismesh/polaris-sidecar/pkg/constants"
+	"github.com/polarismesh/polaris-sidecar/pkg/graceful"
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
-func NewServer(conf *common.ResolverConfig, recurseProxyConf *recursor.Config) (*Server, error) {
+func NewServer(conf *common.ResolverConfig, recurseProxyConf *recursor.Config,
+	queryLimitConf *limiter.Config, aclConf *acl.Config, rewriteRules []*rewrite.Rule,
+	qtypeConf *qtype.Config) (*Server, error) {
 	namingResolvers := make([]common.NamingResolver, 0, len(conf.Resolvers))
 	for _, resolverCfg := range conf.Resolvers {
 		if !resolverCfg.Enable {
@@ -58,6 +65,23 @@ func NewServer(conf *common.ResolverConfig, recurseProxyConf *recursor.Config) (
 		namingResolvers = append(namingResolvers, handler)
 	}
 	recurseProxy := recursor.BuildProxy(recurseProxyConf)
+	queryLimiter := limiter.New(queryLimitConf)
+	var rewriter *rewrite.Rewriter
+	var policy *qtype.Policy
+	queryACL, err := acl.New(aclConf, resolverZones(conf.Resolvers), conf.Namespace)
+	if nil == err {
+		rewriter, err = rewrite.New(rewriteRules)
+	}
+	if nil == err {
+		policy, err = qtype.New(qtypeConf)
+	}
+	if nil != err {
+		for _, handler := range namingResolvers {
+			handler.Destroy()
+		}
+		log.Errorf("[resolver] fail to init acl, rewrite rules or qtype policy, err: %v", err)
+		return nil, err
+	}
 	udpServer := &dns.Server{
 		Addr: conf.BindIP + constants.ColonSymbol + strconv.FormatUint(uint64(conf.BindPort), 10),
 		Net:  constants.UdpProtocol,
@@ -65,6 +89,10 @@ func NewServer(conf *common.ResolverConfig, recurseProxyConf *recursor.Config) (
 			constants.UdpProtocol,
 			namingResolvers,
 			recurseProxy,
+			queryLimiter,
+			queryACL,
+			rewriter,
+			policy,
 		),
 	}
 	tcpServer := &dns.Server{
@@ -74,6 +102,10 @@ func NewServer(conf *common.ResolverConfig, recurseProxyConf *recursor.Config) (
 			constants.TcpProtocol,
 			namingResolvers,
 			recurseProxy,
+			queryLimiter,
+			queryACL,
+			rewriter,
+			policy,
 		),
 	}
 	return &Server{
@@ -82,6 +114,24 @@ func NewServer(conf *common.ResolverConfig, recurseProxyConf *recursor.Config) (
 	}, nil
 }
 
+// resolverZones returns the suffixes and the namespace search lists of the enabled resolvers
+func resolverZones(resolvers []*common.ConfigEntry) []*acl.Zone {
+	zones := make([]*acl.Zone, 0, len(resolvers))
+	for _, resolverCfg := range resolvers {
+		if !resolverCfg.Enable {
+			continue
+		}
+		zone := &acl.Zone{Suffix: resolverCfg.Suffix}
+		if values, ok := resolverCfg.Option["namespace_search"].([]interface{}); ok {
+			for _, value := range values {
+				zone.NamespaceSearch = append(zone.NamespaceSearch, fmt.Sprint(value))
+			}
+		}
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
 type Server struct {
 	dnsSeverList []*dns.Server
 	resolvers    []common.NamingResolver
@@ -105,13 +155,32 @@ func (svr *Server) Run(ctx context.Context, wg *sync.WaitGroup, errChan chan err
 	for i := range svr.dnsSeverList {
 		go func(dnsSvr *dns.Server) {
 			log.Infof("[resolver] dns server listening %s %s", dnsSvr.Addr, dnsSvr.Net)
-			errChan <- dnsSvr.ListenAndServe()
+			errChan <- listenAndServe(dnsSvr)
 		}(svr.dnsSeverList[i])
 	}
 	<-ctx.Done()
 	log.Infof("[resolver] get context cancel signal, return")
 }
 
+// listenAndServe serve on the listener inherited from the old process if present, so that the queries are not
+// interrupted during the upgrade
+func listenAndServe(dnsSvr *dns.Server) error {
+	if dnsSvr.Net == constants.UdpProtocol {
+		conn, err := graceful.ListenPacket(dnsSvr.Net, dnsSvr.Addr)
+		if err != nil {
+			return err
+		}
+		dnsSvr.PacketConn = conn
+	} else {
+		ln, err := graceful.Listen(dnsSvr.Net, dnsSvr.Addr)
+		if err != nil {
+			return err
+		}
+		dnsSvr.Listener = ln
+	}
+	return dnsSvr.ActivateAndServe()
+}
+
 // Destroy 销毁
 func (svr *Server) Destroy() {
 	svr.once.Do(func() {
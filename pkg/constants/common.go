@@ -17,5 +17,7 @@ const (
 type contextKey string
 
 const (
-	ContextProtocol contextKey = "protocol"
+	ContextProtocol   contextKey = "protocol"
+	ContextRequest    contextKey = "request"
+	ContextRemoteAddr contextKey = "remote_addr"
 )
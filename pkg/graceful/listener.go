@@ -0,0 +1,169 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package graceful
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	// EnvInheritedListeners the comma separated keys of the listeners inherited from the parent process,
+	// the files start from fd 3 in the same order
+	EnvInheritedListeners = "POLARIS_SIDECAR_INHERITED_LISTENERS"
+	// EnvReadyFd the fd of the pipe to notify the parent process that the listeners are taken over
+	EnvReadyFd = "POLARIS_SIDECAR_READY_FD"
+
+	// firstInheritedFd the fd of the first extra file passed by exec
+	firstInheritedFd = 3
+)
+
+// filer the listener can be duplicated as a file to pass to the child process
+type filer interface {
+	File() (*os.File, error)
+}
+
+var (
+	mutex sync.Mutex
+	// inherited the inherited files not taken over yet
+	inherited map[string]*os.File
+	// readyFile the pipe to notify the parent process, nil if not started by the upgrade
+	readyFile *os.File
+	// active the listeners created in this process, which are passed to the child process on upgrade
+	active   = map[string]filer{}
+	loadOnce sync.Once
+	// upgraded whether the listeners have been passed to the child process
+	upgraded bool
+	// upgrading whether the child process is started and not ready yet
+	upgrading bool
+)
+
+func listenerKey(network, address string) string {
+	return network + "://" + address
+}
+
+// loadInherited parse the inherited files from the environments
+func loadInherited() {
+	loadOnce.Do(func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		inherited = map[string]*os.File{}
+		if value := os.Getenv(EnvInheritedListeners); len(value) > 0 {
+			for idx, key := range strings.Split(value, ",") {
+				inherited[key] = os.NewFile(uintptr(firstInheritedFd+idx), key)
+			}
+		}
+		if value := os.Getenv(EnvReadyFd); len(value) > 0 {
+			fd, err := strconv.Atoi(value)
+			if err != nil {
+				log.Errorf("[graceful] invalid ready fd %s, err: %v", value, err)
+				return
+			}
+			readyFile = os.NewFile(uintptr(fd), "ready")
+		}
+		_ = os.Unsetenv(EnvInheritedListeners)
+		_ = os.Unsetenv(EnvReadyFd)
+	})
+}
+
+// takeInherited returns the inherited file of the key, the caller should close it after use
+func takeInherited(key string) *os.File {
+	f, ok := inherited[key]
+	if !ok {
+		return nil
+	}
+	delete(inherited, key)
+	return f
+}
+
+// Listen returns the listener inherited from the parent process, or listen on the address if absent
+func Listen(network, address string) (net.Listener, error) {
+	loadInherited()
+	mutex.Lock()
+	defer mutex.Unlock()
+	key := listenerKey(network, address)
+	var ln net.Listener
+	if f := takeInherited(key); f != nil {
+		var err error
+		ln, err = net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			log.Errorf("[graceful] fail to take over listener %s, listen again, err: %v", key, err)
+			ln = nil
+		} else {
+			log.Infof("[graceful] take over listener %s", key)
+		}
+	}
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen(network, address); err != nil {
+			return nil, err
+		}
+	}
+	if unixLn, ok := ln.(*net.UnixListener); ok {
+		// 接管的 unix socket 默认关闭时不删除文件，这里恢复为删除，升级时再关闭
+		unixLn.SetUnlinkOnClose(true)
+	}
+	if f, ok := ln.(filer); ok {
+		active[key] = f
+	}
+	return ln, nil
+}
+
+// ListenPacket returns the packet conn inherited from the parent process, or listen on the address if absent
+func ListenPacket(network, address string) (net.PacketConn, error) {
+	loadInherited()
+	mutex.Lock()
+	defer mutex.Unlock()
+	key := listenerKey(network, address)
+	var conn net.PacketConn
+	if f := takeInherited(key); f != nil {
+		var err error
+		conn, err = net.FilePacketConn(f)
+		_ = f.Close()
+		if err != nil {
+			log.Errorf("[graceful] fail to take over packet conn %s, listen again, err: %v", key, err)
+			conn = nil
+		} else {
+			log.Infof("[graceful] take over packet conn %s", key)
+		}
+	}
+	if conn == nil {
+		var err error
+		if conn, err = net.ListenPacket(network, address); err != nil {
+			return nil, err
+		}
+	}
+	if f, ok := conn.(filer); ok {
+		active[key] = f
+	}
+	return conn, nil
+}
+
+// Upgraded returns whether the listeners have been passed to the new process, the resources shared with
+// the new process such as the unix socket files should not be removed then
+func Upgraded() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return upgraded
+}
@@ -0,0 +1,103 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package graceful
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListen_TakeOver(t *testing.T) {
+	loadInherited()
+	// 模拟父进程传递的监听器
+	parentLn, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer parentLn.Close()
+	address := parentLn.Addr().String()
+	parentConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer parentConn.Close()
+	packetAddress := parentConn.LocalAddr().String()
+	socketPath := filepath.Join(t.TempDir(), "sds.sock")
+	parentUnixLn, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	parentUnixLn.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	mutex.Lock()
+	for key, ln := range map[string]filer{
+		listenerKey("tcp", address):       parentLn.(filer),
+		listenerKey("udp", packetAddress): parentConn.(filer),
+		listenerKey("unix", socketPath):   parentUnixLn.(filer),
+		listenerKey("tcp", "127.0.0.1:1"): parentLn.(filer),
+	} {
+		f, err := ln.File()
+		assert.NoError(t, err)
+		inherited[key] = f
+	}
+	mutex.Unlock()
+
+	ln, err := Listen("tcp", address)
+	assert.NoError(t, err)
+	defer ln.Close()
+	assert.Equal(t, address, ln.Addr().String())
+	// 父进程关闭后仍然可以接受连接
+	assert.NoError(t, parentLn.Close())
+	go func() {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+	conn, err := ln.Accept()
+	assert.NoError(t, err)
+	_ = conn.Close()
+
+	packetConn, err := ListenPacket("udp", packetAddress)
+	assert.NoError(t, err)
+	defer packetConn.Close()
+	assert.Equal(t, packetAddress, packetConn.LocalAddr().String())
+
+	unixLn, err := Listen("unix", socketPath)
+	assert.NoError(t, err)
+	assert.NoError(t, parentUnixLn.Close())
+	_, err = os.Stat(socketPath)
+	assert.NoError(t, err)
+	// 接管的 unix socket 正常关闭时删除文件
+	assert.NoError(t, unixLn.Close())
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// 未被接管的监听器在就绪时关闭，并通知父进程
+	readyReader, readyWriter, err := os.Pipe()
+	assert.NoError(t, err)
+	defer readyReader.Close()
+	mutex.Lock()
+	readyFile = readyWriter
+	mutex.Unlock()
+	Ready(100 * time.Millisecond)
+	assert.NoError(t, waitReady(readyReader, time.Second))
+	mutex.Lock()
+	assert.Empty(t, inherited)
+	assert.Nil(t, readyFile)
+	mutex.Unlock()
+}
@@ -0,0 +1,181 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package graceful
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/polarismesh/polaris-sidecar/pkg/log"
+)
+
+const (
+	// DefaultReadyTimeout max duration for the old process to wait for the new process to be ready
+	DefaultReadyTimeout = 30 * time.Second
+	// DefaultTakeOverTimeout max duration for the new process to wait for its servers to take over the inherited
+	// listeners, it must be clearly shorter than DefaultReadyTimeout so that the listeners removed from the new
+	// config do not make the old process give up the upgrade
+	DefaultTakeOverTimeout = 5 * time.Second
+
+	readyCheckInterval = 50 * time.Millisecond
+)
+
+// Upgrade start a new process of the current executable with the same arguments, pass the active listeners to it,
+// and wait until it takes over all of them. The caller should drain the in-flight requests and exit after that,
+// the new process is killed if it is not ready within the timeout.
+func Upgrade(timeout time.Duration) error {
+	loadInherited()
+	cmd, readyReader, err := startProcess()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+
+	// 等待期间不持有锁，避免阻塞父进程中的 Listen 和 Upgraded 等调用
+	err = waitReady(readyReader, timeout)
+	mutex.Lock()
+	defer mutex.Unlock()
+	upgrading = false
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("new process %d is not ready: %w", cmd.Process.Pid, err)
+	}
+	for _, ln := range active {
+		if unixLn, ok := ln.(*net.UnixListener); ok {
+			// socket 文件由新进程继续使用，关闭时不能删除
+			unixLn.SetUnlinkOnClose(false)
+		}
+	}
+	upgraded = true
+	_ = cmd.Process.Release()
+	return nil
+}
+
+// startProcess start the new process with the active listeners, and returns the pipe to wait for it to be ready
+func startProcess() (*exec.Cmd, *os.File, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if upgraded {
+		return nil, nil, fmt.Errorf("listeners have been passed to the new process")
+	}
+	if upgrading {
+		return nil, nil, fmt.Errorf("upgrade is in progress")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(active))
+	files := make([]*os.File, 0, len(active)+1)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for key, ln := range active {
+		f, err := ln.File()
+		if err != nil {
+			// 已经关闭的监听器不再传递
+			log.Warnf("[graceful] skip listener %s, err: %v", key, err)
+			continue
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(inheritedEnv(), EnvInheritedListeners+"="+strings.Join(keys, ","),
+		EnvReadyFd+"="+strconv.Itoa(firstInheritedFd+len(keys)))
+	if err = cmd.Start(); err != nil {
+		_ = readyReader.Close()
+		return nil, nil, err
+	}
+	// 父进程持有的写端随 files 一起关闭，子进程退出时读端可以立即返回
+	upgrading = true
+	log.Infof("[graceful] started new process %d with listeners %v", cmd.Process.Pid, keys)
+	return cmd, readyReader, nil
+}
+
+// inheritedEnv returns the environments of the current process without the upgrade ones
+func inheritedEnv() []string {
+	env := make([]string, 0, len(os.Environ()))
+	for _, item := range os.Environ() {
+		if strings.HasPrefix(item, EnvInheritedListeners+"=") || strings.HasPrefix(item, EnvReadyFd+"=") {
+			continue
+		}
+		env = append(env, item)
+	}
+	return env
+}
+
+func waitReady(readyReader *os.File, timeout time.Duration) error {
+	if err := readyReader.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1)
+	if _, err := readyReader.Read(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Ready wait until the inherited listeners are all taken over or the timeout, and then notify the parent process
+// to drain. The listeners not taken over within the timeout, e.g. disabled in the new config, are closed.
+func Ready(timeout time.Duration) {
+	loadInherited()
+	deadline := time.Now().Add(timeout)
+	for {
+		mutex.Lock()
+		remains := len(inherited)
+		if remains == 0 || time.Now().After(deadline) {
+			break
+		}
+		mutex.Unlock()
+		time.Sleep(readyCheckInterval)
+	}
+	defer mutex.Unlock()
+	for key, f := range inherited {
+		log.Warnf("[graceful] inherited listener %s is not taken over, close it", key)
+		_ = f.Close()
+		delete(inherited, key)
+	}
+	if readyFile == nil {
+		return
+	}
+	if _, err := readyFile.Write([]byte{1}); err != nil {
+		log.Errorf("[graceful] fail to notify the parent process, err: %v", err)
+	}
+	_ = readyFile.Close()
+	readyFile = nil
+	log.Infof("[graceful] listeners are taken over, notified the parent process")
+}
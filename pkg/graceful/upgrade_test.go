@@ -0,0 +1,58 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package graceful
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	if len(os.Getenv(EnvReadyFd)) > 0 {
+		// 作为升级启动的新进程，延迟一段时间后通知父进程
+		time.Sleep(500 * time.Millisecond)
+		Ready(time.Second)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestUpgrade_WaitWithoutLock(t *testing.T) {
+	done := make(chan error, 1)
+	go func() {
+		done <- Upgrade(5 * time.Second)
+	}()
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return upgrading
+	}, time.Second, 10*time.Millisecond)
+
+	// 等待新进程就绪期间不阻塞其他调用，也不允许重复升级
+	assert.False(t, Upgraded())
+	assert.Error(t, Upgrade(time.Second))
+	assert.NoError(t, <-done)
+	assert.True(t, Upgraded())
+
+	mutex.Lock()
+	upgraded = false
+	mutex.Unlock()
+}
@@ -104,3 +104,22 @@ func GetLimitAPI() (polarisgo.LimitAPI, error) {
 	}
 	return polarisgo.NewLimitAPIByContext(SDKContext), nil
 }
+
+func GetRouterAPI() (polarisgo.RouterAPI, error) {
+	if SDKContext == nil {
+		log.Errorf("GetRouterAPI failed for polaris SDKContext is nil")
+		return nil, errors.New("polaris SDKContext is nil")
+	}
+	return polarisgo.NewRouterAPIByContext(SDKContext), nil
+}
+
+// GetRouterChain returns the service router chain and the nearby router config configured in SDKContext,
+// the nearby config is nil if absent
+func GetRouterChain() ([]string, config.NearbyConfig) {
+	if SDKContext == nil || SDKContext.GetConfig() == nil || SDKContext.GetConfig().GetConsumer() == nil ||
+		SDKContext.GetConfig().GetConsumer().GetServiceRouter() == nil {
+		return nil, nil
+	}
+	routerConfig := SDKContext.GetConfig().GetConsumer().GetServiceRouter()
+	return routerConfig.GetChain(), routerConfig.GetNearbyConfig()
+}
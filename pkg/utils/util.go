@@ -20,6 +20,7 @@ package utils
 import (
 	"strings"
 
+	"github.com/miekg/dns"
 	"github.com/polarismesh/polaris-go/pkg/config"
 	"github.com/polarismesh/polaris-go/pkg/model"
 
@@ -27,31 +28,102 @@ import (
 	"github.com/polarismesh/polaris-sidecar/pkg/log"
 )
 
-// ParseQname parse the qname into service and suffix
-// qname format: <service>.<namespace>.<suffix>
-func ParseQname(qname string, suffix string, currentNs string) *model.ServiceKey {
+// SearchCurrentNamespace is the placeholder in the namespace search list which refers to the sidecar namespace
+const SearchCurrentNamespace = "current"
+
+// ParseQnameCandidates parse the qname into the ordered service keys to lookup
+// qname format: <service>.<namespace>.<suffix> or <service>.<suffix>
+// dots inside the service name must be escaped as "\.", e.g. foo\.bar.default.
+// when the namespace is absent, one key is returned for each namespace in searchNamespaces
+func ParseQnameCandidates(qname string, suffix string, currentNs string, searchNamespaces []string) []*model.ServiceKey {
 	var matched bool
 	qname, matched = MatchSuffix(qname, suffix)
 	if !matched {
 		log.Infof("[utils] parse qname %s failed, suffix %s not match", qname, suffix)
 		return nil
 	}
-	qname = RemoveQuota(qname)
-	var namespace string
-	var serviceName string
-	// quota not found, use current namespace
-	sepIndex := strings.LastIndex(qname, constants.DotSymbol)
-	if sepIndex < 0 {
-		namespace = currentNs
-		serviceName = qname
-	} else {
-		namespace = qname[sepIndex+1:]
-		if strings.ToLower(namespace) == constants.SysNamespace {
-			namespace = config.ServerNamespace
+	labels := dns.SplitDomainName(qname)
+	if len(labels) == 0 {
+		return nil
+	}
+	for i := range labels {
+		labels[i] = UnescapeLabel(labels[i])
+	}
+	if len(labels) > 1 {
+		serviceName := strings.Join(labels[:len(labels)-1], constants.DotSymbol)
+		namespace := normalizeNamespace(labels[len(labels)-1])
+		return []*model.ServiceKey{{Namespace: namespace, Service: serviceName}}
+	}
+	namespaces := ResolveSearchNamespaces(currentNs, searchNamespaces)
+	keys := make([]*model.ServiceKey, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		keys = append(keys, &model.ServiceKey{Namespace: namespace, Service: labels[0]})
+	}
+	return keys
+}
+
+// ResolveSearchNamespaces replace the placeholders in the search list and remove the duplicated items,
+// the current namespace is used when the search list is empty
+func ResolveSearchNamespaces(currentNs string, searchNamespaces []string) []string {
+	if len(searchNamespaces) == 0 {
+		return []string{currentNs}
+	}
+	namespaces := make([]string, 0, len(searchNamespaces))
+	exists := make(map[string]struct{}, len(searchNamespaces))
+	for _, namespace := range searchNamespaces {
+		if namespace == SearchCurrentNamespace {
+			namespace = currentNs
 		}
-		serviceName = qname[:sepIndex]
+		namespace = normalizeNamespace(namespace)
+		if _, ok := exists[namespace]; ok || len(namespace) == 0 {
+			continue
+		}
+		exists[namespace] = struct{}{}
+		namespaces = append(namespaces, namespace)
 	}
-	return &model.ServiceKey{Namespace: namespace, Service: serviceName}
+	return namespaces
+}
+
+func normalizeNamespace(namespace string) string {
+	if strings.ToLower(namespace) == constants.SysNamespace {
+		return config.ServerNamespace
+	}
+	return namespace
+}
+
+// EscapeLabel escape the dots inside the name so that it can be used as a single dns label
+func EscapeLabel(name string) string {
+	return strings.ReplaceAll(name, constants.DotSymbol, "\\"+constants.DotSymbol)
+}
+
+// UnescapeLabel convert the dns label in presentation format to its raw value,
+// both \X and \DDD escapes are supported
+func UnescapeLabel(label string) string {
+	if !strings.Contains(label, "\\") {
+		return label
+	}
+	var sb strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 >= len(label) {
+			sb.WriteByte(label[i])
+			continue
+		}
+		if i+3 < len(label) && isDigit(label[i+1]) && isDigit(label[i+2]) && isDigit(label[i+3]) {
+			value := int(label[i+1]-'0')*100 + int(label[i+2]-'0')*10 + int(label[i+3]-'0')
+			if value <= 255 {
+				sb.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(label[i+1])
+		i++
+	}
+	return sb.String()
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
 }
 
 // MatchSuffix match the suffix and return the split qname
@@ -8,70 +8,71 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestParseQname(t *testing.T) {
+func TestParseQnameCandidates(t *testing.T) {
 	tests := []struct {
-		name      string
-		qname     string
-		suffix    string
-		currentNs string
-		expected  *model.ServiceKey
+		name             string
+		qname            string
+		searchNamespaces []string
+		expected         []*model.ServiceKey
 	}{
 		{
-			name:      "后缀不匹配",
-			qname:     "service.ns.svc.cluster.local",
-			suffix:    "cluster.local",
-			currentNs: "default",
-			expected: &model.ServiceKey{
-				Namespace: "svc",
-				Service:   "service.ns",
+			name:  "未配置搜索列表",
+			qname: "service.svc.cluster.local.",
+			expected: []*model.ServiceKey{
+				{Namespace: "default", Service: "service"},
 			},
 		},
 		{
-			name:      "后缀匹配但qname以点结尾",
-			qname:     "service.ns.svc.cluster.local.",
-			suffix:    "svc.cluster.local",
-			currentNs: "default",
-			expected: &model.ServiceKey{
-				Namespace: "ns",
-				Service:   "service",
+			name:             "按搜索列表顺序查找",
+			qname:            "service.svc.cluster.local.",
+			searchNamespaces: []string{SearchCurrentNamespace, "shared", "polaris", "default"},
+			expected: []*model.ServiceKey{
+				{Namespace: "default", Service: "service"},
+				{Namespace: "shared", Service: "service"},
+				{Namespace: config.ServerNamespace, Service: "service"},
 			},
 		},
 		{
-			name:      "没有命名空间分隔符",
-			qname:     "service.svc.cluster.local",
-			suffix:    "svc.cluster.local",
-			currentNs: "default",
-			expected: &model.ServiceKey{
-				Namespace: "default",
-				Service:   "service",
+			name:             "携带命名空间时忽略搜索列表",
+			qname:            "service.production.svc.cluster.local.",
+			searchNamespaces: []string{SearchCurrentNamespace, "shared"},
+			expected: []*model.ServiceKey{
+				{Namespace: "production", Service: "service"},
 			},
 		},
 		{
-			name:      "命名空间为polaris",
-			qname:     "service.polaris.svc.cluster.local",
-			suffix:    "svc.cluster.local",
-			currentNs: "default",
-			expected: &model.ServiceKey{
-				Namespace: config.ServerNamespace,
-				Service:   "service",
+			name:             "服务名包含转义的点",
+			qname:            "foo\\.bar.svc.cluster.local.",
+			searchNamespaces: []string{SearchCurrentNamespace, "shared"},
+			expected: []*model.ServiceKey{
+				{Namespace: "default", Service: "foo.bar"},
+				{Namespace: "shared", Service: "foo.bar"},
 			},
 		},
 		{
-			name:      "正常情况",
-			qname:     "service.production.svc.cluster.local",
-			suffix:    "svc.cluster.local",
-			currentNs: "default",
-			expected: &model.ServiceKey{
-				Namespace: "production",
-				Service:   "service",
+			name:  "服务名包含转义的点且携带命名空间",
+			qname: "foo\\046bar.production.svc.cluster.local.",
+			expected: []*model.ServiceKey{
+				{Namespace: "production", Service: "foo.bar"},
 			},
 		},
+		{
+			name:     "后缀不匹配",
+			qname:    "service.production.cluster.local.",
+			expected: nil,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := ParseQname(tt.qname, tt.suffix, tt.currentNs)
+			result := ParseQnameCandidates(tt.qname, "svc.cluster.local", "default", tt.searchNamespaces)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
 }
+
+func TestEscapeLabel(t *testing.T) {
+	escaped := EscapeLabel("foo.bar")
+	assert.Equal(t, "foo\\.bar", escaped)
+	assert.Equal(t, "foo.bar", UnescapeLabel(escaped))
+}